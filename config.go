@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -14,10 +15,72 @@ type Host struct {
 	Addr   string    `json:"address"`
 	RTT    string    `json:"rtt,omitempty"`
 	Stat   bool      `json:"status"`
+	State  string    `json:"state"`
 	Times  int       `json:"failed,omitempty"`
 	Last   time.Time `json:"last,omitempty"`
 	AreaID string    `json:"areaID"`
 	Area   string    `json:"area"`
+	//ICMP探测参数
+	Size int `json:"size"`
+	TTL  int `json:"ttl"`
+	DSCP int `json:"dscp"`
+	//源IP地址或者网络接口
+	Source string `json:"source,omitempty"`
+	//网络命名空间
+	Netns string `json:"netns,omitempty"`
+	//探测到的路径MTU和期望值
+	PMTU       int `json:"pmtu,omitempty"`
+	PMTUExpect int `json:"pmtu_expect,omitempty"`
+	//最近一次离线时的路由跟踪: 通过/status/trace查询
+	Trace *traceroute `json:"-"`
+	//上级设备: area/name
+	Parent   string `json:"parent,omitempty"`
+	parent   *Host
+	children []*Host
+	//定期跟踪的路径: 用于推断拓扑
+	path *traceroute
+	//报警规则设置的级别和额外的接收人
+	Severity string `json:"severity,omitempty"`
+	rcpt     []string
+	//最近的RTT记录
+	rtts []time.Duration
+	//离线通知的时间、最近一次提醒的时间、提醒次数和升级后的接收人
+	alertAt  time.Time
+	remindAt time.Time
+	remind   int
+	to       []string
+	//标签: 用于维护窗口
+	Tags []string `json:"tags,omitempty"`
+	//在维护窗口内
+	Maintenance bool `json:"maintenance,omitempty"`
+	//离线确认，恢复后清除
+	Ack *Ack `json:"ack,omitempty"`
+	//维护期间离线，没有发送离线通知
+	maintDown bool
+	//最近一次离线和上线的时间: Last每次收到回复都会更新
+	DownSince time.Time `json:"down_since,omitempty"`
+	UpSince   time.Time `json:"up_since,omitempty"`
+}
+
+//当前状态的开始时间
+func (h *Host) since() time.Time {
+	if h.Stat {
+		return h.UpSince
+	}
+	return h.DownSince
+}
+
+//输出JSON时增加当前状态的持续时间
+func (h *Host) MarshalJSON() ([]byte, error) {
+	type host Host
+	var duration string
+	if since := h.since(); !since.IsZero() {
+		duration = time.Since(since).Truncate(time.Second).String()
+	}
+	return json.Marshal(&struct {
+		*host
+		Duration string `json:"duration,omitempty"`
+	}{(*host)(h), duration})
 }
 
 func Get(h []*Host, addr string) *Host {
@@ -29,12 +92,20 @@ func Get(h []*Host, addr string) *Host {
 	return nil
 }
 
+//主机的ICMP探测参数
+func (h *Host) probeOption() probeOption {
+	return probeOption{Size: h.Size, TTL: h.TTL, DSCP: h.DSCP, Source: h.Source, Netns: h.Netns}
+}
+
 //实现String()，返回字符串
 func (h *Host) String() string {
 	s := "down"
 	if h.Stat {
 		s = "up"
 	}
+	if h.State != "" {
+		s = h.State
+	}
 	var format = "2006-01-02 15:04:05 CST"
 	str := fmt.Sprintf("area: %s name: %s address: %s %s, last time: %s",
 		h.Area, h.Name, h.Addr, s, h.Last.Format(format))
@@ -63,14 +134,28 @@ type Mailer struct {
 
 //配置数据结构
 type Config struct {
-	Debug     bool                  `json:"debug"`
-	Mail      Mailer                `json:"mail"`
-	RelayTime int                   `json:"relay_time,omitempty"`
-	Heartbeat string                `json:"heartbeat"`
-	Interval  string                `json:"interval"`
-	Times     int                   `json:"times,string"`
-	Hosts     []*Host               `json:"hosts"`
-	MailResv  map[string]chan *Host `json:"-"`
+	Debug            bool                        `json:"debug"`
+	Mail             Mailer                      `json:"mail"`
+	RelayTime        int                         `json:"relay_time,omitempty"`
+	Heartbeat        string                      `json:"heartbeat"`
+	Interval         string                      `json:"interval"`
+	Times            int                         `json:"times,string"`
+	PMTUInterval     string                      `json:"pmtu_interval,omitempty"`
+	TopologyInterval string                      `json:"topology_interval,omitempty"`
+	OutageRatio      int                         `json:"outage_ratio,omitempty"`
+	OutageMinHosts   int                         `json:"outage_min_hosts,omitempty"`
+	RepeatInterval   string                      `json:"repeat_interval,omitempty"`
+	Escalation       []*Escalation               `json:"escalation,omitempty"`
+	Notifiers        []*NotifierConfig           `json:"-"`
+	ServiceNotifiers []string                    `json:"-"`
+	MQTT             *MQTTConfig                 `json:"-"`
+	Hosts            []*Host                     `json:"hosts"`
+	Groups           map[string]*Group           `json:"-"`
+	Services         []*Service                  `json:"-"`
+	RulesDir         string                      `json:"-"`
+	MaintenanceFile  string                      `json:"-"`
+	SNMPDir          string                      `json:"-"`
+	MailResv         map[string]chan *Transition `json:"-"`
 }
 
 //读取配置信息
@@ -81,24 +166,58 @@ func ConfigReader(jc *jsonconfig) *Config {
 	c.Heartbeat = jc.Global.Heartbeat
 	c.Interval = jc.Global.Interval
 	c.Times = jc.Global.Times
-	c.MailResv = make(map[string]chan *Host)
+	c.MailResv = make(map[string]chan *Transition)
 	c.RelayTime = jc.Global.RelayTime
+	c.PMTUInterval = jc.Global.PMTUInterval
+	c.TopologyInterval = jc.Global.TopologyInterval
+	c.OutageRatio = jc.Global.OutageRatio
+	c.OutageMinHosts = jc.Global.OutageMinHosts
+	c.RepeatInterval = jc.Global.RepeatInterval
+	c.Escalation = jc.Global.Escalation
+	c.Notifiers = jc.Global.Notifiers
+	c.ServiceNotifiers = jc.Global.ServiceNotifiers
+	c.MQTT = jc.Global.MQTT
+	c.Groups = jc.Groups
+	c.Services = jc.Global.Services
 	var emails = make(map[string]string)
+	var parents = make(map[string]string)
 	for k, group := range jc.Groups {
 		emails[k] = group.Email
 		for i := 0; i < len(group.Hosts); i++ {
 			h := group.Hosts[i]
-			mrsv := make(chan *Host, len(group.Hosts))
+			mrsv := make(chan *Transition, len(group.Hosts))
 			c.MailResv[group.Area] = mrsv
+			opt := group.probeOption(h)
+			var parent string
+			if h.Parent != "" {
+				parent = parentKey(group.Area, h.Parent)
+				parents[hostKey(group.Area, h.Name)] = parent
+			}
 			c.Hosts = append(c.Hosts, &Host{
-				Name:   h.Name,
-				Addr:   h.Addr,
-				Area:   group.Name,
-				AreaID: group.Area,
+				Name:       h.Name,
+				Addr:       h.Addr,
+				Area:       group.Name,
+				AreaID:     group.Area,
+				Size:       opt.Size,
+				TTL:        opt.TTL,
+				DSCP:       opt.DSCP,
+				Source:     opt.Source,
+				Netns:      opt.Netns,
+				PMTUExpect: group.pmtuExpect(h),
+				State:      stateDown,
+				Parent:     parent,
+				Tags:       append(append([]string{}, group.Tags...), h.Tags...),
 			})
 		}
 		c.Mail.Emails = emails
 	}
+	linkParents(c.Hosts, parents)
+	//业务服务使用单独的通知区域
+	if len(c.Services) > 0 {
+		c.MailResv[serviceArea] = make(chan *Transition, len(c.Services))
+		emails[serviceArea] = jc.Global.ServiceEmail
+		c.Mail.Emails = emails
+	}
 	return &c
 }
 
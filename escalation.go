@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//升级步骤: 离线通知after之后增加接收人，
+//to为group(组的邮箱)、global(全局接收人)或者逗号分隔的邮箱
+type Escalation struct {
+	After string `json:"after"`
+	To    string `json:"to"`
+}
+
+func (e *Escalation) after() time.Duration {
+	d, _ := time.ParseDuration(e.After)
+	return d
+}
+
+//检查重复通知间隔和升级步骤
+func checkEscalation(repeat string, steps []*Escalation) error {
+	if repeat != "" {
+		if _, err := time.ParseDuration(repeat); err != nil {
+			return fmt.Errorf("repeat_interval: %s", err)
+		}
+	}
+	for i, e := range steps {
+		d, err := time.ParseDuration(e.After)
+		if err != nil || d < 0 {
+			return fmt.Errorf("escalation %d: after %q", i, e.After)
+		}
+		if strings.TrimSpace(e.To) == "" {
+			return fmt.Errorf("escalation %d: to is empty", i)
+		}
+	}
+	return nil
+}
+
+//离线elapsed时的接收人: 没有升级步骤时返回空，使用默认接收人
+func (m *monitor) escalationRcpt(h *Host, elapsed time.Duration) []string {
+	var to []string
+	for _, e := range m.cfg.Escalation {
+		if e.after() > elapsed {
+			continue
+		}
+		var rcpt string
+		switch e.To {
+		case "group":
+			rcpt = m.cfg.Mail.Emails[h.AreaID]
+		case "global":
+			rcpt = m.cfg.Mail.RcptTo
+		default:
+			rcpt = e.To
+		}
+		if rcpt != "" {
+			to = append(to, rcpt)
+		}
+	}
+	return to
+}
+
+//离线通知后开始主机的离线提醒
+func (m *monitor) startEscalation(h *Host) {
+	if h.Stat || h.State != stateDown || !h.alertAt.IsZero() {
+		return
+	}
+	now := time.Now()
+	h.alertAt, h.remindAt, h.remind = now, now, 0
+	h.to = m.escalationRcpt(h, 0)
+}
+
+//主机恢复时结束离线提醒: 恢复通知被抑制时也需要清除；
+//升级后的接收人保留到下一次离线，恢复通知发送给已经升级的接收人
+func (m *monitor) stopEscalation(h *Host) {
+	h.alertAt, h.remind = time.Time{}, 0
+}
+
+//离线提醒和升级: 主机恢复、确认、静默或者维护时停止
+func (m *monitor) remind(repeat time.Duration) {
+	hosts := append([]*Host{}, m.cfg.Hosts...)
+	for _, s := range m.services {
+		hosts = append(hosts, s.host)
+	}
+
+	now := time.Now()
+	for _, h := range hosts {
+		if h.Stat || h.State != stateDown || h.alertAt.IsZero() ||
+			h.Ack != nil || h.Maintenance || m.silenced(h) != nil {
+			continue
+		}
+		to := m.escalationRcpt(h, now.Sub(h.alertAt))
+		escalated := len(to) > len(h.to)
+		if !escalated && (repeat <= 0 || now.Sub(h.remindAt) < repeat) {
+			continue
+		}
+		h.to, h.remindAt = to, now
+		h.remind++
+		if escalated {
+			m.logger.Printf("[INFO] %s, escalated to %s\n", h, strings.Join(to, ","))
+		} else {
+			m.logger.Printf("[INFO] %s, reminder %d\n", h, h.remind)
+		}
+		m.mail <- newTransition(h, h.State)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testEscalationMonitor(hs ...*Host) *monitor {
+	return &monitor{
+		mail:   make(chan *Transition, 10),
+		logger: log.New(ioutil.Discard, "", 0),
+		cfg: &Config{
+			Hosts: hs,
+			Mail:  Mailer{RcptTo: "noc@example.com", Emails: map[string]string{"a1": "a1@example.com"}},
+			Escalation: []*Escalation{
+				{After: "0s", To: "group"},
+				{After: "15m", To: "global"},
+				{After: "1h", To: "manager@example.com"},
+			},
+		},
+	}
+}
+
+func TestCheckEscalation(t *testing.T) {
+	for _, tc := range []struct {
+		repeat string
+		steps  []*Escalation
+		ok     bool
+	}{
+		{"30m", []*Escalation{{After: "0s", To: "group"}}, true},
+		{"", nil, true},
+		{"30", nil, false},
+		{"", []*Escalation{{After: "-1m", To: "group"}}, false},
+		{"", []*Escalation{{After: "1m", To: " "}}, false},
+	} {
+		if err := checkEscalation(tc.repeat, tc.steps); (err == nil) != tc.ok {
+			t.Errorf("%q %v: err = %v", tc.repeat, tc.steps, err)
+		}
+	}
+}
+
+func TestEscalationRcpt(t *testing.T) {
+	h := &Host{Name: "web1", AreaID: "a1"}
+	m := testEscalationMonitor(h)
+	for _, tc := range []struct {
+		elapsed time.Duration
+		want    []string
+	}{
+		{0, []string{"a1@example.com"}},
+		{15 * time.Minute, []string{"a1@example.com", "noc@example.com"}},
+		{2 * time.Hour, []string{"a1@example.com", "noc@example.com", "manager@example.com"}},
+	} {
+		if got := m.escalationRcpt(h, tc.elapsed); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.elapsed, got, tc.want)
+		}
+	}
+	//组没有邮箱时跳过
+	h.AreaID = "a2"
+	if got := m.escalationRcpt(h, 0); len(got) != 0 {
+		t.Errorf("no group email: %v", got)
+	}
+}
+
+func TestRemind(t *testing.T) {
+	h := &Host{Name: "web1", AreaID: "a1", State: stateDown}
+	m := testEscalationMonitor(h)
+	m.startEscalation(h)
+	if h.alertAt.IsZero() || len(h.to) != 1 {
+		t.Fatalf("escalation not started: %v %v", h.alertAt, h.to)
+	}
+	//没有到升级时间和重复间隔时不发送
+	m.remind(time.Hour)
+	if len(m.mail) != 0 {
+		t.Fatalf("%d mails before escalation", len(m.mail))
+	}
+	h.alertAt = h.alertAt.Add(-20 * time.Minute)
+	m.remind(time.Hour)
+	if len(m.mail) != 1 || h.remind != 1 || len(h.to) != 2 {
+		t.Fatalf("escalated: %d mails, remind %d, to %v", len(m.mail), h.remind, h.to)
+	}
+	//重复提醒
+	h.remindAt = h.remindAt.Add(-time.Hour)
+	m.remind(time.Hour)
+	if len(m.mail) != 2 || h.remind != 2 {
+		t.Fatalf("repeat: %d mails, remind %d", len(m.mail), h.remind)
+	}
+	//确认后停止提醒
+	h.Ack, h.remindAt = &Ack{By: "admin"}, h.remindAt.Add(-time.Hour)
+	m.remind(time.Hour)
+	if len(m.mail) != 2 {
+		t.Errorf("%d mails after ack", len(m.mail))
+	}
+	//恢复后结束，保留升级后的接收人用于恢复通知
+	m.stopEscalation(h)
+	if !h.alertAt.IsZero() || h.remind != 0 || len(h.to) != 2 {
+		t.Errorf("stopped: %v, remind %d, to %v", h.alertAt, h.remind, h.to)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	//日志中记录的脚本输出的最大长度
+	execMaxOutput = 1024
+	//超时结束脚本后等待输出关闭的时间: 脚本的子进程可能仍然占用输出
+	execWaitDelay = 2 * time.Second
+)
+
+//只保存前n字节的输出，其余丢弃: 不嵌入bytes.Buffer，避免io.Copy使用ReadFrom
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	n         int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	room := b.n - b.buf.Len()
+	if len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+//执行本地脚本: 标准输入为事件JSON，环境变量包括区域、主机和状态，输出记录到ping日志
+type execNotifier struct {
+	c      *NotifierConfig
+	logger *log.Logger
+}
+
+//脚本的环境变量
+func (ev *Event) environ() []string {
+	var names, states, notices []string
+	for _, t := range ev.Transitions {
+		names = append(names, t.Name)
+		//路径MTU、路由跟踪和组状态通知不是主机的状态变化
+		if t.Kind != "" {
+			notices = append(notices, t.Name+"="+t.Kind)
+		} else {
+			states = append(states, t.Name+"="+t.To)
+		}
+	}
+	return []string{
+		"GOPING_AREA=" + ev.Area,
+		"GOPING_AREA_NAME=" + ev.AreaName,
+		"GOPING_SUBJECT=" + ev.Subject,
+		"GOPING_SEVERITY=" + ev.Severity,
+		"GOPING_HOSTS=" + strings.Join(names, ","),
+		"GOPING_STATES=" + strings.Join(states, ","),
+		"GOPING_NOTICES=" + strings.Join(notices, ","),
+		fmt.Sprintf("GOPING_COUNT=%d", len(ev.Transitions)),
+		fmt.Sprintf("GOPING_OUTAGES=%d", len(ev.Outages)),
+	}
+}
+
+func (n *execNotifier) Notify(ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.c.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.c.Command, n.c.Args...)
+	cmd.Env = append(os.Environ(), ev.environ()...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.WaitDelay = execWaitDelay
+	out := &limitedBuffer{n: execMaxOutput}
+	cmd.Stdout, cmd.Stderr = out, out
+	err = cmd.Run()
+
+	output := out.String()
+	if out.truncated {
+		output += "..."
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timeout after %s", n.c.timeout())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s, output: %q", n.c.Command, err, output)
+	}
+	n.logger.Printf("[INFO] notify %s: %s exit 0, output: %q\n", n.c.Name, n.c.Command, output)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{n: 5}
+	for _, s := range []string{"abc", "defg", "hij"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Errorf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if b.String() != "abcde" || !b.truncated {
+		t.Errorf("buffer %q, truncated %v", b.String(), b.truncated)
+	}
+	b = &limitedBuffer{n: 5}
+	b.Write([]byte("abcde"))
+	if b.truncated {
+		t.Error("truncated at exact size")
+	}
+}
+
+func TestExecNotifier(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "notify.sh")
+	out := filepath.Join(dir, "event.json")
+	body := "#!/bin/sh\ncat > " + out + "\necho \"$GOPING_AREA $GOPING_STATES $GOPING_NOTICES $1\"\n"
+	if err := ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	n := &execNotifier{&NotifierConfig{Name: "script", Command: script, Args: []string{"--ticket"}}, log.New(&logs, "", 0)}
+	ev := testEvent(stateDown)
+	ev.Transitions = append(ev.Transitions, testPMTUEvent().Transitions[0])
+	if err := n.Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), `exit 0, output: "a1 router=down router=pmtu --ticket\n"`) {
+		t.Errorf("log %q", logs.String())
+	}
+	if b, err := ioutil.ReadFile(out); err != nil || !strings.Contains(string(b), `"subject":"[a1] site1`) {
+		t.Errorf("stdin %s, %v", b, err)
+	}
+
+	//失败时返回退出状态和输出
+	n.c.Command, n.c.Args = "/bin/sh", []string{"-c", "echo failed; exit 3"}
+	if err := n.Notify(ev); err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("err = %v", err)
+	}
+	//输出超过execMaxOutput时截断
+	n.c.Args = []string{"-c", "head -c 5000 /dev/zero | tr '\\0' x; exit 1"}
+	if err := n.Notify(ev); err == nil || !strings.Contains(err.Error(), strings.Repeat("x", execMaxOutput)+`..."`) ||
+		strings.Contains(err.Error(), strings.Repeat("x", execMaxOutput+1)) {
+		t.Errorf("err = %.100v", err)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip(err)
+	}
+	//后台子进程仍然占用输出时也在超时后返回
+	n := &execNotifier{&NotifierConfig{Name: "script", Command: "/bin/sh", Args: []string{"-c", "sleep 10 & sleep 10"},
+		Timeout: "100ms"}, log.New(ioutil.Discard, "", 0)}
+	start := time.Now()
+	err := n.Notify(testEvent(stateDown))
+	if err == nil || !strings.Contains(err.Error(), "timeout after 100ms") {
+		t.Errorf("err = %v", err)
+	}
+	if d := time.Since(start); d > execWaitDelay+time.Second {
+		t.Errorf("returned after %s", d)
+	}
+}
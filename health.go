@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//组状态
+const (
+	groupUp      = "up"
+	groupPartial = "partial"
+	groupDown    = "down"
+	//全部主机状态未知
+	groupUnknown = "unknown"
+
+	//默认离线主机达到50%时组状态为down
+	defaultDownRatio = 50
+)
+
+//组状态通知规则
+type groupAlert struct {
+	//进入这些状态时发送通知: up, partial, down
+	States []string `json:"states"`
+	//接收人: 为空时使用全局和组的email
+	Email string `json:"email,omitempty"`
+}
+
+//组的汇总状态: /status/groups
+type groupHealth struct {
+	Area  string    `json:"areaID"`
+	Name  string    `json:"area"`
+	State string    `json:"state"`
+	Up    int       `json:"up"`
+	Down  int       `json:"down"`
+	Total int       `json:"total"`
+	Since time.Time `json:"since"`
+	//离线比例达到此百分比时为down
+	DownRatio int `json:"down_ratio"`
+	//不计算维护中主机的状态，用于发送通知
+	alert *groupHealth
+}
+
+//根据主机状态计算组状态: 没有离线为up，离线比例达到down_ratio为down，其余为partial；
+//启动后还没有结果的主机不计算，all为false时也不计算维护中的主机
+func (m *monitor) groupHealth(g *Group, all bool) *groupHealth {
+	gh := &groupHealth{Area: g.Area, Name: g.Name, DownRatio: g.DownRatio}
+	if gh.DownRatio <= 0 {
+		gh.DownRatio = defaultDownRatio
+	}
+	for _, h := range m.cfg.Hosts {
+		if h.AreaID != g.Area || (!all && h.Maintenance) {
+			continue
+		}
+		gh.Total++
+		if h.Stat {
+			gh.Up++
+		} else if h.Times >= m.cfg.Times {
+			gh.Down++
+		}
+	}
+	switch {
+	case gh.Up+gh.Down == 0:
+		gh.State = groupUnknown
+	case gh.Down == 0:
+		gh.State = groupUp
+	case gh.Down*100 >= gh.Total*gh.DownRatio:
+		gh.State = groupDown
+	default:
+		gh.State = groupPartial
+	}
+	return gh
+}
+
+//更新全部组的状态，不计算维护中主机的状态变化时按组的通知规则发送邮件；
+//全部主机都在维护中时保留维护前的状态，窗口结束后与之比较
+func (m *monitor) updateGroups() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for area, g := range m.cfg.Groups {
+		gh := m.groupHealth(g, true)
+		gh.alert = m.groupHealth(g, false)
+		prev, ok := m.groups[area]
+		if ok && gh.alert.State == groupUnknown {
+			gh.alert = prev.alert
+		}
+		if ok && prev.State == gh.State {
+			gh.Since = prev.Since
+		} else {
+			gh.Since = time.Now()
+			if ok && prev.State != groupUnknown {
+				m.logger.Printf("[INFO] group %s state %s -> %s, down %d/%d\n",
+					area, prev.State, gh.State, gh.Down, gh.Total)
+			}
+		}
+		m.groups[area] = gh
+		if !ok || prev.alert.State == groupUnknown || prev.alert.State == gh.alert.State {
+			continue
+		}
+		gh.alert.Since = time.Now()
+		if g.Alert == nil || !contains(g.Alert.States, gh.alert.State) {
+			continue
+		}
+		if s := m.silencedTarget(area); s != nil {
+			m.logger.Printf("[INFO] group %s, silenced by %s until %s\n", area, s.By, s.Expire.Format(time.RFC3339))
+			continue
+		}
+		//不经过relay_time合并，直接发送到组的通知渠道
+		m.dispatch(area, groupEvent(g, gh.alert, prev.alert.State), nil)
+	}
+}
+
+//按区域排序的组状态
+func (m *monitor) groupList() []*groupHealth {
+	var list = make([]*groupHealth, 0)
+	m.mu.RLock()
+	for _, gh := range m.groups {
+		list = append(list, gh)
+	}
+	m.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Area < list[j].Area })
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//组状态变化通知: 组状态通知规则指定接收人时代替全局和组的接收人
+func groupEvent(g *Group, gh *groupHealth, prev string) *Event {
+	t := &Transition{
+		Key:    g.Area,
+		Name:   g.Name,
+		Area:   g.Area,
+		Group:  g.Name,
+		From:   prev,
+		To:     gh.State,
+		Kind:   noticeGroup,
+		Last:   gh.Since,
+		Health: gh,
+	}
+	if g.Alert.Email != "" {
+		t.Rcpt = []string{g.Alert.Email}
+	}
+	return &Event{
+		Area:        g.Area,
+		AreaName:    g.Name,
+		Time:        gh.Since,
+		Subject:     fmt.Sprintf("[%s] %s - %s: %s", g.Area, g.Name, noticeSubjects[noticeGroup], gh.State),
+		Transitions: []*Transition{t},
+	}
+}
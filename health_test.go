@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupHealth(t *testing.T) {
+	g := &Group{Area: "a", Name: "site a"}
+	hs := outageHosts("a", 1, 4)
+	m := &monitor{cfg: &Config{Times: 3, Hosts: append(hs, outageHosts("b", 2, 2)...)}}
+
+	//启动后还没有结果
+	if gh := m.groupHealth(g, true); gh.State != groupUnknown || gh.Total != 4 || gh.DownRatio != defaultDownRatio {
+		t.Errorf("initial %+v", gh)
+	}
+	for _, h := range hs {
+		h.Stat = true
+	}
+	if gh := m.groupHealth(g, true); gh.State != groupUp || gh.Up != 4 {
+		t.Errorf("all up %+v", gh)
+	}
+	//失败次数没有达到times时不计为离线
+	hs[0].Stat, hs[0].Times = false, 2
+	if gh := m.groupHealth(g, true); gh.State != groupUp || gh.Up != 3 || gh.Down != 0 {
+		t.Errorf("failing %+v", gh)
+	}
+	hs[0].Times = 3
+	if gh := m.groupHealth(g, true); gh.State != groupPartial || gh.Down != 1 {
+		t.Errorf("partial %+v", gh)
+	}
+	hs[1].Stat, hs[1].Times = false, 3
+	if gh := m.groupHealth(g, true); gh.State != groupDown || gh.Down != 2 {
+		t.Errorf("down %+v", gh)
+	}
+	g.DownRatio = 75
+	if gh := m.groupHealth(g, true); gh.State != groupPartial {
+		t.Errorf("down_ratio 75 %+v", gh)
+	}
+}
+
+func TestUpdateGroups(t *testing.T) {
+	g := &Group{Area: "a", Name: "site a"}
+	hs := outageHosts("a", 1, 2)
+	m := &monitor{
+		cfg:    &Config{Times: 3, Hosts: hs, Groups: map[string]*Group{"a": g}},
+		groups: make(map[string]*groupHealth),
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+	m.updateGroups()
+	if gh := m.groupList(); len(gh) != 1 || gh[0].State != groupUnknown {
+		t.Fatalf("groups %+v", gh)
+	}
+
+	hs[0].Stat, hs[1].Stat = true, true
+	m.updateGroups()
+	since := m.groups["a"].Since
+	time.Sleep(time.Millisecond)
+	//状态没有变化时保留开始时间
+	m.updateGroups()
+	if gh := m.groups["a"]; gh.State != groupUp || !gh.Since.Equal(since) {
+		t.Errorf("unchanged %+v, since %s", gh, since)
+	}
+	hs[0].Stat, hs[0].Times = false, 3
+	m.updateGroups()
+	if gh := m.groups["a"]; gh.State != groupDown || !gh.Since.After(since) {
+		t.Errorf("changed %+v", gh)
+	}
+}
+
+func TestUpdateGroupsMaintenance(t *testing.T) {
+	g := &Group{Area: "a", Name: "site a"}
+	hs := outageHosts("a", 1, 2)
+	for _, h := range hs {
+		h.Stat = true
+	}
+	m := &monitor{
+		cfg:    &Config{Times: 3, Hosts: hs, Groups: map[string]*Group{"a": g}},
+		groups: make(map[string]*groupHealth),
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+	m.updateGroups()
+	//维护中的主机离线不改变通知使用的状态
+	hs[0].Maintenance, hs[0].Stat, hs[0].Times = true, false, 3
+	m.updateGroups()
+	if gh := m.groups["a"]; gh.State != groupDown || gh.alert.State != groupUp || gh.alert.Total != 1 {
+		t.Errorf("partial maintenance %+v, alert %+v", gh, gh.alert)
+	}
+	//全部主机都在维护中时保留维护前的状态
+	hs[1].Maintenance, hs[1].Stat, hs[1].Times = true, false, 3
+	m.updateGroups()
+	if gh := m.groups["a"]; gh.alert.State != groupUp {
+		t.Errorf("all in maintenance, alert %+v", gh.alert)
+	}
+	hs[0].Maintenance, hs[1].Maintenance = false, false
+	m.updateGroups()
+	if gh := m.groups["a"]; gh.alert.State != groupDown || gh.alert.Since.IsZero() {
+		t.Errorf("maintenance ended, alert %+v", gh.alert)
+	}
+}
+
+func TestUpdateGroupsNotify(t *testing.T) {
+	g := &Group{Area: "a", Name: "site a", Alert: &groupAlert{States: []string{groupDown}, Email: "manager@example.com"}}
+	hs := outageHosts("a", 1, 2)
+	for _, h := range hs {
+		h.Stat = true
+	}
+	n := &fakeNotifier{events: make(chan *Event, 1)}
+	m := &monitor{
+		cfg:      &Config{Times: 3, Hosts: hs, Groups: map[string]*Group{"a": g}},
+		groups:   make(map[string]*groupHealth),
+		logger:   log.New(ioutil.Discard, "", 0),
+		channels: map[string][]*channel{"a": {{"fake", n}}},
+	}
+	m.updateGroups()
+	hs[0].Stat, hs[0].Times = false, 3
+	m.updateGroups()
+	//组状态通知发送到组的通知渠道
+	ev := n.wait(t)
+	tr := ev.Transitions[0]
+	if ev.Subject != "[a] site a - 区域状态变化通知: down" || tr.Kind != noticeGroup || tr.From != groupUp || tr.Health.Down != 1 {
+		t.Errorf("event %+v, transition %+v", ev, tr)
+	}
+	if rcpt := mailRcpt(Mailer{RcptTo: "noc@example.com"}, ev, ""); rcpt != "manager@example.com" {
+		t.Errorf("rcpt %s", rcpt)
+	}
+	if body := mailBody(ev); !strings.Contains(body, "在线 1，离线 1，总数 2") || strings.Contains(body, "上线") {
+		t.Errorf("body %q", body)
+	}
+}
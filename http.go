@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,16 @@ var re = regexp.MustCompile(`[^[:digit:]]`)
 type jsonhost struct {
 	Name string `json:"name"`
 	Addr string `json:"address"`
+	//ICMP探测参数: 为0时使用组的设置; dscp为空时使用组的设置，可以设置为0
+	Size int  `json:"size,omitempty"`
+	TTL  int  `json:"ttl,omitempty"`
+	DSCP *int `json:"dscp,omitempty"`
+	//期望的路径MTU: 大于0时探测路径MTU
+	PMTU int `json:"pmtu,omitempty"`
+	//上级设备: 同组的主机名称或者area/name，上级离线时本机标记为不可达
+	Parent string `json:"parent,omitempty"`
+	//标签: 用于维护窗口
+	Tags []string `json:"tags,omitempty"`
 }
 
 //按组分类的主机信息
@@ -36,6 +47,26 @@ type Group struct {
 	Name  string      `json:"name"`
 	Email string      `json:"email"`
 	Hosts []*jsonhost `json:"hosts"`
+	//ICMP探测参数: 为0时使用默认值, size 32, ttl 64, dscp 0
+	Size int `json:"size,omitempty"`
+	TTL  int `json:"ttl,omitempty"`
+	DSCP int `json:"dscp,omitempty"`
+	//期望的路径MTU: 如1500，低于此值时报警
+	PMTU int `json:"pmtu,omitempty"`
+	//探测使用的源IP地址或者网络接口名称，如"10.0.1.2"或"eth1"
+	Source string `json:"source,omitempty"`
+	//探测所在的linux网络命名空间: ip netns的名称或者路径，用于地址重叠的VRF
+	Netns string `json:"netns,omitempty"`
+	//离线主机达到此百分比时组状态为down，默认50
+	DownRatio int `json:"down_ratio,omitempty"`
+	//组状态变化的通知规则，为空时不发送组状态通知
+	Alert *groupAlert `json:"group_alert,omitempty"`
+	//组内全部主机的标签
+	Tags []string `json:"tags,omitempty"`
+	//通知渠道名称，为空时使用email
+	Notifiers []string `json:"notifiers,omitempty"`
+	//短信接收号码或者短信渠道中的联系人名称
+	Phones []string `json:"phones,omitempty"`
 
 	//配置保存路径: 不打印JSON
 	path string
@@ -49,6 +80,28 @@ type Global struct {
 	Times     int    `json:"times,string"`
 	RelayTime int    `json:"relay_time"`
 	Mail      Mailer `json:"mail"`
+	//路径MTU探测间隔，默认5m
+	PMTUInterval string `json:"pmtu_interval,omitempty"`
+	//跟踪全部主机路径以推断拓扑的间隔，默认1h，0s表示不跟踪
+	TopologyInterval string `json:"topology_interval,omitempty"`
+	//站点故障: 组或者/24网段中离线主机达到此百分比时合并为一个通知，0表示不合并
+	OutageRatio int `json:"outage_ratio,omitempty"`
+	//站点故障的最少离线主机数，默认3
+	OutageMinHosts int `json:"outage_min_hosts,omitempty"`
+	//业务服务和状态变化通知的接收人
+	Services     []*Service `json:"services,omitempty"`
+	ServiceEmail string     `json:"service_email,omitempty"`
+	//离线期间重复通知的间隔，为空时不重复
+	RepeatInterval string `json:"repeat_interval,omitempty"`
+	//升级策略: 离线一段时间后增加接收人
+	Escalation []*Escalation `json:"escalation,omitempty"`
+	//通知渠道，组通过名称引用; 业务服务使用的渠道
+	Notifiers        []*NotifierConfig `json:"notifiers,omitempty"`
+	ServiceNotifiers []string          `json:"service_notifiers,omitempty"`
+	//MQTT状态发布
+	MQTT *MQTTConfig `json:"mqtt,omitempty"`
+	//管理员用户名和密码: 确认和静默接口使用HTTP基本认证
+	Admins map[string]string `json:"admins,omitempty"`
 }
 
 func ReadGroup(file string) (*Group, error) {
@@ -65,6 +118,90 @@ func ReadGroup(file string) (*Group, error) {
 	return &g, nil
 }
 
+//检查组配置
+func (g *Group) check() error {
+	if strings.HasPrefix(g.Area, "@") {
+		return fmt.Errorf("group %s: area starting with @ is reserved", g.Area)
+	}
+	if err := checkProbeOption(g.Size, g.TTL, g.DSCP); err != nil {
+		return fmt.Errorf("group %s: %s", g.Area, err)
+	}
+	if g.PMTU < 0 || g.PMTU > maxPMTU {
+		return fmt.Errorf("group %s: pmtu must be between 0 and %d", g.Area, maxPMTU)
+	}
+	if g.DownRatio < 0 || g.DownRatio > 100 {
+		return fmt.Errorf("group %s: down_ratio must be between 0 and 100", g.Area)
+	}
+	if g.Alert != nil {
+		for _, state := range g.Alert.States {
+			if state != groupUp && state != groupPartial && state != groupDown {
+				return fmt.Errorf("group %s: group_alert state %s", g.Area, state)
+			}
+		}
+	}
+	//网络接口在组的命名空间中检查
+	if g.Source != "" && net.ParseIP(g.Source) == nil {
+		err := inNetns(g.Netns, func() error {
+			_, err := net.InterfaceByName(g.Source)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("group %s: source %s", g.Area, err)
+		}
+	} else if g.Netns != "" {
+		if err := inNetns(g.Netns, func() error { return nil }); err != nil {
+			return fmt.Errorf("group %s: %s", g.Area, err)
+		}
+	}
+	for _, h := range g.Hosts {
+		if err := checkProbeOption(h.Size, h.TTL, h.dscp()); err != nil {
+			return fmt.Errorf("group %s host %s: %s", g.Area, h.Name, err)
+		}
+		if h.PMTU < 0 || h.PMTU > maxPMTU {
+			return fmt.Errorf("group %s host %s: pmtu must be between 0 and %d", g.Area, h.Name, maxPMTU)
+		}
+	}
+	return nil
+}
+
+//主机期望的路径MTU: 主机设置优先于组设置
+func (g *Group) pmtuExpect(h *jsonhost) int {
+	if h.PMTU != 0 {
+		return h.PMTU
+	}
+	return g.PMTU
+}
+
+//主机的ICMP探测参数: 主机设置优先于组设置
+func (g *Group) probeOption(h *jsonhost) probeOption {
+	opt := probeOption{Size: defaultSize, TTL: defaultTTL, Source: g.Source, Netns: g.Netns}
+	if g.Size != 0 {
+		opt.Size = g.Size
+	}
+	if g.TTL != 0 {
+		opt.TTL = g.TTL
+	}
+	opt.DSCP = g.DSCP
+	if h.Size != 0 {
+		opt.Size = h.Size
+	}
+	if h.TTL != 0 {
+		opt.TTL = h.TTL
+	}
+	if h.DSCP != nil {
+		opt.DSCP = *h.DSCP
+	}
+	return opt
+}
+
+//主机设置的DSCP，没有设置时为0
+func (h *jsonhost) dscp() int {
+	if h.DSCP == nil {
+		return 0
+	}
+	return *h.DSCP
+}
+
 type jsonconfig struct {
 	Global *Global           `json:"global"`
 	Groups map[string]*Group `json:"groups,omitempty"`
@@ -104,9 +241,29 @@ func JsonConfigReader(cpath, gdir string) (*jsonconfig, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := g.check(); err != nil {
+			return nil, err
+		}
 		//println(g.Area)
 		groups[g.Area] = g
 	}
+	if err := checkParents(groups); err != nil {
+		return nil, err
+	}
+	if err := checkServices(global.Services, groups); err != nil {
+		return nil, err
+	}
+	if err := checkEscalation(global.RepeatInterval, global.Escalation); err != nil {
+		return nil, err
+	}
+	if err := checkNotifiers(global.Notifiers, global.ServiceNotifiers, groups); err != nil {
+		return nil, err
+	}
+	if global.MQTT != nil {
+		if err := global.MQTT.check(); err != nil {
+			return nil, err
+		}
+	}
 
 	return &jsonconfig{Global: &global, Groups: groups}, nil
 }
@@ -257,7 +414,32 @@ func hideSecret(global *Global) *Global {
 			SmtpHost: global.Mail.SmtpHost,
 			SmtpPort: global.Mail.SmtpPort,
 		},
-		RelayTime: global.RelayTime,
+		RelayTime:        global.RelayTime,
+		PMTUInterval:     global.PMTUInterval,
+		TopologyInterval: global.TopologyInterval,
+		OutageRatio:      global.OutageRatio,
+		OutageMinHosts:   global.OutageMinHosts,
+		Services:         global.Services,
+		ServiceEmail:     global.ServiceEmail,
+		RepeatInterval:   global.RepeatInterval,
+		Escalation:       global.Escalation,
+		ServiceNotifiers: global.ServiceNotifiers,
+	}
+	for _, c := range global.Notifiers {
+		glob.Notifiers = append(glob.Notifiers, c.hideSecret())
+	}
+	if global.MQTT != nil {
+		mc := *global.MQTT
+		if mc.Password != "" {
+			mc.Password = "******"
+		}
+		glob.MQTT = &mc
+	}
+	if len(global.Admins) > 0 {
+		glob.Admins = make(map[string]string)
+		for user := range global.Admins {
+			glob.Admins[user] = "******"
+		}
 	}
 	return &glob
 }
@@ -358,11 +540,24 @@ func splitHost(s string) ([]*jsonhost, error) {
 				return nil, errors.New(fmt.Sprintf("hosts line %v: %s, address resolve failed", i, hline))
 			}
 		}
-		jhs = append(jhs, &jsonhost{host[0], host[1]})
+		jhs = append(jhs, &jsonhost{Name: host[0], Addr: host[1]})
 	}
 	return jhs, nil
 }
 
+//保留已有主机的其他设置: 页面只提交名称和地址
+func mergeHosts(old, hs []*jsonhost) []*jsonhost {
+	for _, h := range hs {
+		for _, o := range old {
+			if o.Name == h.Name && o.Addr == h.Addr {
+				*h = *o
+				break
+			}
+		}
+	}
+	return hs
+}
+
 //接收post的全局参数, 并保存
 func setting(w http.ResponseWriter, r *http.Request, l *log.Logger, jc *jsonconfig, cfgPath string) {
 	if r.Method == "POST" {
@@ -506,7 +701,15 @@ func groupSetting(w http.ResponseWriter, r *http.Request, l *log.Logger, jc *jso
 			fmt.Fprintf(w, "%s", err)
 			return
 		}
-		g.Hosts = hs
+		old := g.Hosts
+		g.Hosts = mergeHosts(g.Hosts, hs)
+		if err := checkParents(jc.Groups); err != nil {
+			g.Hosts = old
+			l.Printf("[Error] client %s 更新主机列表, area: %s, %s\n", r.RemoteAddr, area, err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s", err)
+			return
+		}
 
 		//检查邮箱名
 		if email := r.FormValue("email"); email != "" {
@@ -538,6 +741,185 @@ func groupSetting(w http.ResponseWriter, r *http.Request, l *log.Logger, jc *jso
 	}
 }
 
+//接受推断的上级设备: 保存到组配置
+func acceptTopology(w http.ResponseWriter, r *http.Request, l *log.Logger, jc *jsonconfig) {
+	if err := r.ParseForm(); err != nil {
+		l.Printf("[Error] client %s 更新上级设备, %s\n", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	//host和parent格式为area/name，parent为空时删除上级设备
+	key, parent := r.FormValue("host"), r.FormValue("parent")
+	var g *Group
+	var h *jsonhost
+	for area, group := range jc.Groups {
+		for _, jh := range group.Hosts {
+			if hostKey(area, jh.Name) == key {
+				g, h = group, jh
+			}
+		}
+	}
+	if h == nil {
+		l.Printf("[Error] client %s 更新上级设备, host %s 不存在\n", r.RemoteAddr, key)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "host %s not found", key)
+		return
+	}
+	//同组的上级设备只保存名称
+	if strings.HasPrefix(parent, g.Area+"/") {
+		parent = strings.TrimPrefix(parent, g.Area+"/")
+	}
+	old := h.Parent
+	h.Parent = parent
+	if err := checkParents(jc.Groups); err != nil {
+		h.Parent = old
+		l.Printf("[Error] client %s 更新上级设备, %s\n", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	if err := JsonConfigWrite(g.path, g, true); err != nil {
+		l.Printf("[Error] client %s 更新区域 %s 时: 配置备份失败 %s", r.RemoteAddr, g.Area, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err)
+		return
+	}
+	l.Printf("[Success] client %s 更新 %s 上级设备为 %q\n", r.RemoteAddr, key, parent)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Content-Type", "text/json; charset=utf-8")
+	fmt.Fprint(w, `{"topology":"OK"}`)
+}
+
+//维护窗口文件的读写锁
+var maintMu sync.Mutex
+
+//维护窗口管理: GET查询; POST todo=add添加，todo=delete删除，同时清除已经结束的一次性窗口
+func maintenanceSetting(w http.ResponseWriter, r *http.Request, l *log.Logger, file string) {
+	maintMu.Lock()
+	defer maintMu.Unlock()
+	list, err := ReadMaintenance(file)
+	if err != nil {
+		l.Printf("[Error] client %s 读取维护窗口, %s\n", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "text/json; charset=utf-8")
+		json.NewEncoder(w).Encode(list)
+		return
+	case "POST":
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		l.Printf("[Error] client %s 更新维护窗口, %s\n", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id := r.FormValue("id")
+	switch r.FormValue("todo") {
+	case "add":
+		if id == "" {
+			id = strconv.FormatInt(time.Now().UnixNano(), 36)
+		}
+		mw := &Maintenance{
+			ID:       id,
+			Comment:  r.FormValue("comment"),
+			Hosts:    splitList(r.FormValue("hosts")),
+			Groups:   splitList(r.FormValue("groups")),
+			Tags:     splitList(r.FormValue("tags")),
+			Start:    r.FormValue("start"),
+			End:      r.FormValue("end"),
+			Time:     r.FormValue("time"),
+			Timezone: r.FormValue("timezone"),
+		}
+		for _, s := range splitList(r.FormValue("weekdays")) {
+			d, err := strconv.Atoi(s)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "weekday %q", s)
+				return
+			}
+			mw.Weekdays = append(mw.Weekdays, d)
+		}
+		if err := mw.check(); err != nil {
+			l.Printf("[Error] client %s 添加维护窗口, %s\n", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err)
+			return
+		}
+		for _, v := range list {
+			if v.ID == id {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "maintenance %s exists", id)
+				return
+			}
+		}
+		list = append(list, mw)
+	case "delete":
+		var found bool
+		for i, v := range list {
+			if v.ID == id {
+				list = append(list[:i], list[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "maintenance %s not found", id)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "todo must be add or delete")
+		return
+	}
+
+	var keep = make([]*Maintenance, 0)
+	for _, v := range list {
+		if !v.expired(time.Now()) {
+			keep = append(keep, v)
+		}
+	}
+	//文件存在时备份
+	_, err = os.Stat(file)
+	if err := JsonConfigWrite(file, keep, err == nil); err != nil {
+		l.Printf("[Error] client %s 保存维护窗口失败 %s\n", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err)
+		return
+	}
+	l.Printf("[Success] client %s %s 维护窗口 %s\n", r.RemoteAddr, r.FormValue("todo"), id)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Content-Type", "text/json; charset=utf-8")
+	fmt.Fprintf(w, `{"maintenance":"OK","id":%q}`, id)
+}
+
+//HTTP基本认证: 返回用户名，没有配置管理员时拒绝
+func basicAuth(w http.ResponseWriter, r *http.Request, l *log.Logger, admins map[string]string) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if ok {
+		if secret, found := admins[user]; found && secret != "" &&
+			subtle.ConstantTimeCompare([]byte(secret), []byte(pass)) == 1 {
+			return user, true
+		}
+		l.Printf("[Error] client %s 认证失败, user: %s\n", r.RemoteAddr, user)
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="goping"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprint(w, "unauthorized")
+	return "", false
+}
+
 //runCmd执行子命令: 端口为ping守护进程提供status查询的http端口
 func (srv *Server) runCmd(name, port string, l *log.Logger) error {
 	cmd := exec.Command(name, "-port", port, "run")
@@ -637,7 +1019,7 @@ func logs(w http.ResponseWriter, r *http.Request, l *log.Logger, pl string) {
 	}
 }
 
-func (srv *Server) Listen(addr string, jcfg *jsonconfig, l *log.Logger, port, cfgPath, gDir, pingLogFilePath, httpLogFile string) {
+func (srv *Server) Listen(addr string, jcfg *jsonconfig, l *log.Logger, port, cfgPath, gDir, pingLogFilePath, httpLogFile, maintFile string) {
 	srv.HandleFunc("/admin/setting", func(w http.ResponseWriter, r *http.Request) {
 		config(w, r, l, jcfg)
 	})
@@ -650,6 +1032,7 @@ func (srv *Server) Listen(addr string, jcfg *jsonconfig, l *log.Logger, port, cf
 	proxy := httputil.NewSingleHostReverseProxy(ls)
 	proxy.ErrorLog = l
 	srv.Handle("/status", proxy)
+	srv.Handle("/status/", proxy)
 
 	srv.HandleFunc("/admin/setting/global", func(w http.ResponseWriter, r *http.Request) {
 		setting(w, r, l, jcfg, cfgPath)
@@ -657,6 +1040,37 @@ func (srv *Server) Listen(addr string, jcfg *jsonconfig, l *log.Logger, port, cf
 	srv.HandleFunc("/admin/setting/group", func(w http.ResponseWriter, r *http.Request) {
 		groupSetting(w, r, l, jcfg, gDir)
 	})
+	//GET: 查询ping守护进程推断的拓扑; POST: 接受建议的上级设备
+	srv.HandleFunc("/admin/topology", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			r.URL.Path = "/status/topology"
+			proxy.ServeHTTP(w, r)
+		case "POST":
+			acceptTopology(w, r, l, jcfg)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	//确认和静默: 认证后转发到ping守护进程
+	silence := func(w http.ResponseWriter, r *http.Request) {
+		user, ok := basicAuth(w, r, l, jcfg.Global.Admins)
+		if !ok {
+			return
+		}
+		//不读取请求内容，由反向代理转发
+		l.Printf("[INFO] client %s user %s %s %s\n", r.RemoteAddr, user, r.Method, r.URL.Path)
+		r.Header.Set(userHeader, user)
+		proxy.ServeHTTP(w, r)
+	}
+	srv.HandleFunc("/admin/ack", silence)
+	srv.HandleFunc("/admin/silence", silence)
+
+	//维护窗口: ping守护进程检测到文件变化后重新读取
+	srv.HandleFunc("/admin/setting/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		maintenanceSetting(w, r, l, maintFile)
+	})
 
 	srv.runCmd(os.Args[0], port, l)
 
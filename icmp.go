@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	//ICMP头部长度
+	icmpHeaderLen = 8
+	//报文中保存发送时间的长度
+	timeSliceLength = 8
+
+	//默认ICMP Echo数据大小32byte
+	defaultSize = 32
+	defaultTTL  = 64
+)
+
+//每个Pinger使用不同的ICMP Echo ID
+var (
+	pingerID   = os.Getpid() & 0xffff
+	pingerLock sync.Mutex
+)
+
+func nextPingerID() int {
+	pingerLock.Lock()
+	defer pingerLock.Unlock()
+	pingerID = (pingerID + 1) & 0xffff
+	return pingerID
+}
+
+//ICMP探测参数: 相同参数的主机共用一个Pinger
+type probeOption struct {
+	Size int
+	TTL  int
+	DSCP int
+	//源IP地址或者网络接口名称
+	Source string
+	//linux网络命名空间
+	Netns string
+}
+
+func (opt probeOption) String() string {
+	s := fmt.Sprintf("size %d ttl %d dscp %d", opt.Size, opt.TTL, opt.DSCP)
+	if opt.Source != "" {
+		s += " source " + opt.Source
+	}
+	if opt.Netns != "" {
+		s += " netns " + opt.Netns
+	}
+	return s
+}
+
+//Pinger 按照fastping的方式工作: 每个周期向全部地址发送一次Echo，
+//MaxRTT内收到回复调用OnRecv，周期结束调用OnIdle；
+//与fastping不同的是可以设置TTL和TOS/DSCP
+type Pinger struct {
+	MaxRTT time.Duration
+	//ICMP Echo数据大小
+	Size int
+	//IPv4 TTL或IPv6 Hop Limit
+	TTL int
+	//DSCP, 写入IPv4 TOS或IPv6 Traffic Class的高6位
+	DSCP int
+	//源IP地址或者网络接口名称，为空时由路由决定
+	Source string
+	//在此网络命名空间中创建socket，为空时使用默认命名空间
+	Netns string
+
+	OnRecv func(*net.IPAddr, time.Duration)
+	OnIdle func()
+
+	id    int
+	seq   int
+	addrs map[string]*net.IPAddr
+	done  chan bool
+	err   error
+	mu    sync.Mutex
+}
+
+func NewPinger(opt probeOption) *Pinger {
+	return &Pinger{
+		MaxRTT: time.Second,
+		Size:   opt.Size,
+		TTL:    opt.TTL,
+		DSCP:   opt.DSCP,
+		Source: opt.Source,
+		Netns:  opt.Netns,
+		id:     nextPingerID(),
+		addrs:  make(map[string]*net.IPAddr),
+		done:   make(chan bool),
+	}
+}
+
+func (p *Pinger) AddIPAddr(ip *net.IPAddr) {
+	p.mu.Lock()
+	p.addrs[ip.String()] = ip
+	p.mu.Unlock()
+}
+
+//Pinger因为错误退出时关闭
+func (p *Pinger) Done() <-chan bool {
+	return p.done
+}
+
+func (p *Pinger) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+//在goroutine中循环发送ICMP Echo
+func (p *Pinger) RunLoop() {
+	go func() {
+		if err := p.run(); err != nil {
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+			close(p.done)
+		}
+	}()
+}
+
+func (p *Pinger) run() error {
+	var has4, has6 bool
+	for _, ip := range p.addrs {
+		if isIPv4(ip.IP) {
+			has4 = true
+		} else {
+			has6 = true
+		}
+	}
+
+	var c4, c6 *net.IPConn
+	var err error
+	if has4 {
+		if c4, err = p.listen(true); err != nil {
+			return err
+		}
+		defer c4.Close()
+	}
+	if has6 {
+		if c6, err = p.listen(false); err != nil {
+			return err
+		}
+		defer c6.Close()
+	}
+
+	for {
+		p.seq = (p.seq + 1) & 0xffff
+		deadline := time.Now().Add(p.MaxRTT)
+
+		p.mu.Lock()
+		for _, ip := range p.addrs {
+			c := c4
+			if !isIPv4(ip.IP) {
+				c = c6
+			}
+			//发送失败等同于超时，由OnIdle处理
+			c.WriteTo(p.echo(ip.IP), ip)
+		}
+		p.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, c := range []*net.IPConn{c4, c6} {
+			if c == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(c *net.IPConn) {
+				defer wg.Done()
+				p.recv(c, deadline)
+			}(c)
+		}
+		wg.Wait()
+
+		if p.OnIdle != nil {
+			p.OnIdle()
+		}
+	}
+}
+
+//在网络命名空间中创建ICMP连接，绑定源地址并设置TTL和DSCP
+func (p *Pinger) listen(v4 bool) (*net.IPConn, error) {
+	var conn *net.IPConn
+	err := inNetns(p.Netns, func() error {
+		var err error
+		conn, err = p.listenConn(v4)
+		return err
+	})
+	return conn, err
+}
+
+func (p *Pinger) listenConn(v4 bool) (*net.IPConn, error) {
+	network, laddr := "ip4:icmp", "0.0.0.0"
+	if !v4 {
+		network, laddr = "ip6:ipv6-icmp", "::"
+	}
+	var ifname string
+	if p.Source != "" {
+		if ip := net.ParseIP(p.Source); ip != nil {
+			if isIPv4(ip) != v4 {
+				return nil, fmt.Errorf("source %s: address family mismatch", p.Source)
+			}
+			laddr = p.Source
+		} else {
+			ip, err := interfaceAddr(p.Source, v4)
+			if err != nil {
+				return nil, err
+			}
+			ifname, laddr = p.Source, ip.String()
+		}
+	}
+	c, err := net.ListenPacket(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.IPConn)
+	if ifname != "" {
+		if err := bindToDevice(conn, ifname); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err := setProbeSockopt(conn, v4, p.TTL, p.DSCP); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+//返回网络接口上对应协议的第一个地址
+func interfaceAddr(name string, v4 bool) (net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || isIPv4(ipnet.IP) != v4 {
+			continue
+		}
+		//IPv6跳过链路本地地址
+		if !v4 && ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipnet.IP, nil
+	}
+	return nil, fmt.Errorf("interface %s has no usable address", name)
+}
+
+//发送一次ICMP Echo并等待回复: df为true时设置Don't Fragment
+func echoOnce(opt probeOption, ip *net.IPAddr, df bool, timeout time.Duration) (time.Duration, error) {
+	p := NewPinger(opt)
+	p.seq = 1
+	v4 := isIPv4(ip.IP)
+	c, err := p.listen(v4)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	if df {
+		if err := setDontFragment(c, v4); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := c.WriteTo(p.echo(ip.IP), ip); err != nil {
+		return 0, err
+	}
+
+	b := make([]byte, 65536)
+	c.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, ra, err := c.ReadFrom(b)
+		if err != nil {
+			return 0, err
+		}
+		if !ra.(*net.IPAddr).IP.Equal(ip.IP) {
+			continue
+		}
+		if rtt, ok := p.parseReply(b[:n]); ok {
+			return rtt, nil
+		}
+	}
+}
+
+//读取ICMP Echo Reply，直到deadline
+func (p *Pinger) recv(c *net.IPConn, deadline time.Time) {
+	b := make([]byte, 65536)
+	c.SetReadDeadline(deadline)
+	for {
+		n, ra, err := c.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		rtt, ok := p.parseReply(b[:n])
+		if !ok {
+			continue
+		}
+		if p.OnRecv != nil {
+			p.OnRecv(ra.(*net.IPAddr), rtt)
+		}
+	}
+}
+
+//生成ICMP Echo Request: 数据前8字节为发送时间
+func (p *Pinger) echo(ip net.IP) []byte {
+	size := p.Size
+	if size < timeSliceLength {
+		size = timeSliceLength
+	}
+	b := make([]byte, icmpHeaderLen+size)
+	b[0] = icmpv4EchoRequest
+	if !isIPv4(ip) {
+		b[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(p.id))
+	binary.BigEndian.PutUint16(b[6:], uint16(p.seq))
+	binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+	for i := icmpHeaderLen + timeSliceLength; i < len(b); i++ {
+		b[i] = 1
+	}
+	//IPv6的校验和由内核计算
+	if isIPv4(ip) {
+		binary.BigEndian.PutUint16(b[2:], checksum(b))
+	}
+	return b
+}
+
+//检查Echo Reply是否属于本Pinger的当前周期，并返回RTT
+func (p *Pinger) parseReply(b []byte) (time.Duration, bool) {
+	if len(b) < icmpHeaderLen+timeSliceLength {
+		return 0, false
+	}
+	if b[0] != icmpv4EchoReply && b[0] != icmpv6EchoReply {
+		return 0, false
+	}
+	if int(binary.BigEndian.Uint16(b[4:])) != p.id || int(binary.BigEndian.Uint16(b[6:])) != p.seq {
+		return 0, false
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(b[8:])))
+	return time.Since(sent), true
+}
+
+func checksum(b []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s>>16 != 0 {
+		s = s>>16 + s&0xffff
+	}
+	return ^uint16(s)
+}
+
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
+
+//检查ICMP探测参数
+func checkProbeOption(size, ttl, dscp int) error {
+	if size != 0 && (size < timeSliceLength || size > 65000) {
+		return errors.New("size must be between 8 and 65000")
+	}
+	if ttl != 0 && (ttl < 1 || ttl > 255) {
+		return errors.New("ttl must be between 1 and 255")
+	}
+	if dscp < 0 || dscp > 63 {
+		return errors.New("dscp must be between 0 and 63")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestChecksum(t *testing.T) {
+	//RFC 1071的示例
+	b := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	if got := checksum(b); got != 0x220d {
+		t.Errorf("checksum = %#x, want 0x220d", got)
+	}
+	//奇数长度: 最后一个字节补零
+	if got := checksum([]byte{0x01}); got != ^uint16(0x0100) {
+		t.Errorf("odd checksum = %#x", got)
+	}
+	//包括校验和的报文再次计算为0
+	b = append([]byte{8, 0, 0, 0}, b...)
+	binary.BigEndian.PutUint16(b[2:], checksum(b))
+	if got := checksum(b); got != 0 {
+		t.Errorf("verify checksum = %#x, want 0", got)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	p := NewPinger(probeOption{Size: 4})
+	p.seq = 7
+	b := p.echo(net.ParseIP("10.0.0.1"))
+	//数据最少为发送时间的8字节
+	if len(b) != icmpHeaderLen+timeSliceLength {
+		t.Fatalf("length %d, want %d", len(b), icmpHeaderLen+timeSliceLength)
+	}
+	if b[0] != icmpv4EchoRequest || b[1] != 0 {
+		t.Errorf("type %d code %d", b[0], b[1])
+	}
+	if id, seq := binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:]); int(id) != p.id || seq != 7 {
+		t.Errorf("id %d seq %d, want %d 7", id, seq, p.id)
+	}
+	if sent := time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))); time.Since(sent) > time.Second {
+		t.Errorf("timestamp %s", sent)
+	}
+	if checksum(b) != 0 {
+		t.Error("bad ipv4 checksum")
+	}
+
+	p = NewPinger(probeOption{Size: 32})
+	b = p.echo(net.ParseIP("2001:db8::1"))
+	if len(b) != icmpHeaderLen+32 || b[0] != icmpv6EchoRequest {
+		t.Fatalf("ipv6 echo type %d length %d", b[0], len(b))
+	}
+	//IPv6的校验和由内核计算
+	if b[2] != 0 || b[3] != 0 {
+		t.Errorf("ipv6 checksum % x, want 0", b[2:4])
+	}
+	for i := icmpHeaderLen + timeSliceLength; i < len(b); i++ {
+		if b[i] != 1 {
+			t.Fatalf("payload[%d] = %d, want 1", i, b[i])
+		}
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	p := NewPinger(probeOption{Size: 32})
+	p.seq = 3
+	reply := func(typ byte, id, seq int, ago time.Duration) []byte {
+		b := p.echo(net.ParseIP("10.0.0.1"))
+		b[0] = typ
+		binary.BigEndian.PutUint16(b[4:], uint16(id))
+		binary.BigEndian.PutUint16(b[6:], uint16(seq))
+		binary.BigEndian.PutUint64(b[8:], uint64(time.Now().Add(-ago).UnixNano()))
+		return b
+	}
+
+	rtt, ok := p.parseReply(reply(icmpv4EchoReply, p.id, 3, 20*time.Millisecond))
+	if !ok || rtt < 20*time.Millisecond || rtt > time.Second {
+		t.Errorf("ipv4 reply: rtt %s, ok %v", rtt, ok)
+	}
+	if _, ok := p.parseReply(reply(icmpv6EchoReply, p.id, 3, 0)); !ok {
+		t.Error("ipv6 reply not accepted")
+	}
+	for name, b := range map[string][]byte{
+		"request":   reply(icmpv4EchoRequest, p.id, 3, 0),
+		"other id":  reply(icmpv4EchoReply, p.id+1, 3, 0),
+		"old seq":   reply(icmpv4EchoReply, p.id, 2, 0),
+		"truncated": reply(icmpv4EchoReply, p.id, 3, 0)[:icmpHeaderLen+timeSliceLength-1],
+	} {
+		if _, ok := p.parseReply(b); ok {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestCheckProbeOption(t *testing.T) {
+	for _, tc := range []struct {
+		size, ttl, dscp int
+		ok              bool
+	}{
+		{0, 0, 0, true},
+		{32, 64, 46, true},
+		{7, 0, 0, false},
+		{65001, 0, 0, false},
+		{0, 256, 0, false},
+		{0, -1, 0, false},
+		{0, 0, 64, false},
+		{0, 0, -1, false},
+	} {
+		if err := checkProbeOption(tc.size, tc.ttl, tc.dscp); (err == nil) != tc.ok {
+			t.Errorf("checkProbeOption(%d, %d, %d) = %v", tc.size, tc.ttl, tc.dscp, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	//Alertmanager需要定期重新发送仍然离线的报警
+	alertmanagerResend = time.Minute
+)
+
+//外部系统去重使用的标识: 主机为goping/area/name，路径MTU为goping/area/name:pmtu，组为goping/area
+func dedupKey(t *Transition) string {
+	if t.Kind == noticePMTU {
+		return "goping/" + t.Key + ":pmtu"
+	}
+	return "goping/" + t.Key
+}
+
+//主机离线、路径MTU过低和组不是全部在线时为trigger，恢复时为resolve
+func (t *Transition) firing() bool {
+	return !t.ok()
+}
+
+//是否发送到外部系统: 离线提醒的状态没有变化，不重复发送
+func (t *Transition) incident() bool {
+	return t.Kind != "" || t.From != t.To
+}
+
+//报警的摘要
+func (t *Transition) summary() string {
+	switch t.Kind {
+	case noticePMTU:
+		return fmt.Sprintf("%s %s (%s) path MTU %d, expected %d", t.Group, t.Name, t.Address, t.PMTU, t.PMTUExpect)
+	case noticeGroup:
+		return fmt.Sprintf("%s is %s: %d/%d hosts down", t.Name, t.To, t.Health.Down, t.Health.Total)
+	}
+	return fmt.Sprintf("%s %s (%s) is %s", t.Group, t.Name, t.Address, t.To)
+}
+
+//PagerDuty Events API v2
+type pagerdutyNotifier struct {
+	c *NotifierConfig
+}
+
+func (n *pagerdutyNotifier) stateful() {}
+
+type pdPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     string                 `json:"timestamp,omitempty"`
+	Component     string                 `json:"component,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	Class         string                 `json:"class,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pdEvent struct {
+	RoutingKey  string     `json:"routing_key"`
+	EventAction string     `json:"event_action"`
+	DedupKey    string     `json:"dedup_key"`
+	Payload     *pdPayload `json:"payload,omitempty"`
+}
+
+//报警级别对应的PagerDuty级别，默认为error
+func pdSeverity(s string) string {
+	switch s {
+	case "info", "warning", "critical":
+		return s
+	}
+	return "error"
+}
+
+func (n *pagerdutyNotifier) Notify(ev *Event) error {
+	u := n.c.URL
+	if u == "" {
+		u = defaultPagerDutyURL
+	}
+	for _, t := range ev.Transitions {
+		if !t.incident() {
+			continue
+		}
+		e := &pdEvent{
+			RoutingKey:  n.c.RoutingKey,
+			EventAction: "resolve",
+			DedupKey:    dedupKey(t),
+		}
+		if t.firing() {
+			e.EventAction = "trigger"
+			e.Payload = &pdPayload{
+				Summary:   t.summary(),
+				Source:    t.Address,
+				Severity:  pdSeverity(t.Severity),
+				Component: t.Name,
+				Group:     t.Area,
+				Class:     "ping",
+				CustomDetails: map[string]interface{}{
+					"from":      t.From,
+					"to":        t.To,
+					"last":      t.Last,
+					"remind":    t.Remind,
+					"area_name": t.Group,
+				},
+			}
+			if ts := t.DownSince; !ts.IsZero() && t.Kind != noticePMTU && t.Kind != noticeGroup {
+				e.Payload.Timestamp = ts.Format(time.RFC3339)
+			}
+			switch t.Kind {
+			case noticePMTU:
+				e.Payload.Severity, e.Payload.Class = "warning", "pmtu"
+				e.Payload.CustomDetails["pmtu"] = t.PMTU
+				e.Payload.CustomDetails["pmtu_expect"] = t.PMTUExpect
+			case noticeGroup:
+				e.Payload.Source, e.Payload.Class = t.Name, "group"
+				e.Payload.CustomDetails["health"] = t.Health
+			case noticeTrace:
+				//相同dedup_key的trigger追加到已有的报警
+				e.Payload.CustomDetails["traceroute"] = t.Trace.String()
+			}
+		}
+		if _, err := postJSON(n.c, u, n.c.Headers, e); err != nil {
+			return fmt.Errorf("%s %s: %s", e.EventAction, e.DedupKey, err)
+		}
+	}
+	return nil
+}
+
+//Prometheus Alertmanager /api/v2/alerts
+type alertmanagerNotifier struct {
+	c *NotifierConfig
+
+	once sync.Once
+	mu   sync.Mutex
+	//仍然离线的报警，定期重新发送
+	firing map[string]*amAlert
+}
+
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+func newAlertmanagerNotifier(c *NotifierConfig) *alertmanagerNotifier {
+	return &alertmanagerNotifier{c: c, firing: make(map[string]*amAlert)}
+}
+
+func (n *alertmanagerNotifier) stateful() {}
+
+func (n *alertmanagerNotifier) alert(t *Transition) *amAlert {
+	severity := t.Severity
+	if severity == "" {
+		severity = "critical"
+	}
+	start := t.DownSince
+	if start.IsZero() || t.Kind == noticePMTU || t.Kind == noticeGroup {
+		start = time.Now()
+	}
+	a := &amAlert{
+		Labels: map[string]string{
+			"alertname": "HostDown",
+			"dedup_key": dedupKey(t),
+			"area":      t.Area,
+			"host":      t.Name,
+			"address":   t.Address,
+			"severity":  severity,
+		},
+		Annotations: map[string]string{
+			"summary": t.summary(),
+			"group":   t.Group,
+		},
+		StartsAt:     start,
+		GeneratorURL: n.c.GeneratorURL,
+	}
+	switch t.Kind {
+	case noticePMTU:
+		a.Labels["alertname"], a.Labels["severity"] = "PathMTULow", "warning"
+	case noticeGroup:
+		a.Labels = map[string]string{
+			"alertname": "GroupDown",
+			"dedup_key": dedupKey(t),
+			"area":      t.Area,
+			"severity":  severity,
+		}
+		if t.To == groupPartial {
+			a.Labels["alertname"], a.Labels["severity"] = "GroupPartial", "warning"
+		}
+	case noticeTrace:
+		if t.Trace != nil {
+			a.Annotations["traceroute"] = t.Trace.String()
+		}
+	}
+	return a
+}
+
+func (n *alertmanagerNotifier) Notify(ev *Event) error {
+	n.once.Do(func() { go n.resend() })
+
+	now := time.Now()
+	var alerts []*amAlert
+	n.mu.Lock()
+	for _, t := range ev.Transitions {
+		if !t.incident() {
+			continue
+		}
+		a := n.alert(t)
+		old, ok := n.firing[dedupKey(t)]
+		if t.firing() {
+			//标签变化时(组从部分离线变为离线、报警级别变化)先结束之前的报警
+			if ok && !reflect.DeepEqual(old.Labels, a.Labels) {
+				old.EndsAt = now
+				alerts = append(alerts, old)
+			} else if ok {
+				a.StartsAt = old.StartsAt
+			}
+			a.EndsAt = now.Add(3 * alertmanagerResend)
+			n.firing[dedupKey(t)] = a
+		} else {
+			//恢复: 使用离线时的标签，结束时间为当前时间；被抑制的恢复只结束已经发送的报警
+			if ok {
+				a.Labels, a.StartsAt = old.Labels, old.StartsAt
+			} else if t.Suppressed {
+				continue
+			}
+			a.EndsAt = now
+			delete(n.firing, dedupKey(t))
+		}
+		alerts = append(alerts, a)
+	}
+	n.mu.Unlock()
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	_, err := postJSON(n.c, n.c.URL, n.c.Headers, alerts)
+	return err
+}
+
+//定期重新发送仍然离线的报警，避免Alertmanager按resolve_timeout自动恢复
+func (n *alertmanagerNotifier) resend() {
+	for range time.Tick(alertmanagerResend) {
+		n.mu.Lock()
+		var alerts []*amAlert
+		for _, a := range n.firing {
+			a.EndsAt = time.Now().Add(3 * alertmanagerResend)
+			alerts = append(alerts, a)
+		}
+		n.mu.Unlock()
+		if len(alerts) > 0 {
+			postJSON(n.c, n.c.URL, n.c.Headers, alerts)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPagerDuty(t *testing.T) {
+	s := newTestServer(t, http.StatusAccepted, "")
+	n := &pagerdutyNotifier{&NotifierConfig{Name: "pd", URL: s.URL, RoutingKey: "rk"}}
+
+	down := testEvent(stateDown)
+	down.Transitions[0].Severity = "warning"
+	up := testEvent(stateUp)
+	//被抑制的恢复也要结束报警
+	up.Transitions[0].Suppressed = true
+	//离线提醒不重复发送
+	remind := testEvent(stateDown)
+	remind.Transitions[0].From, remind.Transitions[0].Remind = stateDown, 1
+	for _, ev := range []*Event{down, remind, up} {
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := s.all()
+	if len(b) != 2 {
+		t.Fatalf("got %d events, want 2", len(b))
+	}
+	var trigger, resolve pdEvent
+	if err := json.Unmarshal(b[0], &trigger); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b[1], &resolve); err != nil {
+		t.Fatal(err)
+	}
+	if trigger.RoutingKey != "rk" || trigger.EventAction != "trigger" || trigger.DedupKey != "goping/a1/router" {
+		t.Errorf("trigger = %s", b[0])
+	}
+	p := trigger.Payload
+	if p == nil || p.Severity != "warning" || p.Source != "10.0.0.1" || p.Component != "router" ||
+		p.Group != "a1" || p.Summary != "site1 router (10.0.0.1) is down" || p.Timestamp == "" {
+		t.Errorf("trigger payload = %s", b[0])
+	}
+	if resolve.EventAction != "resolve" || resolve.DedupKey != trigger.DedupKey || resolve.Payload != nil {
+		t.Errorf("resolve = %s", b[1])
+	}
+}
+
+func TestPagerDutyNotices(t *testing.T) {
+	s := newTestServer(t, http.StatusAccepted, "")
+	n := &pagerdutyNotifier{&NotifierConfig{Name: "pd", URL: s.URL, RoutingKey: "rk"}}
+
+	//路径MTU变化是单独的报警，不是主机恢复
+	low := testPMTUEvent()
+	ok := testPMTUEvent()
+	ok.Transitions[0].PMTU = 1500
+	trace := testEvent(stateDown)
+	trace.Transitions[0].Kind, trace.Transitions[0].From = noticeTrace, stateDown
+	trace.Transitions[0].Trace = &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}}}
+	group := &Event{Transitions: []*Transition{{Kind: noticeGroup, Key: "a1", Name: "site1", Area: "a1", Group: "site1",
+		From: groupUp, To: groupDown, Health: &groupHealth{Down: 4, Total: 4}}}}
+	for _, ev := range []*Event{low, ok, trace, group} {
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := s.all()
+	if len(b) != 4 {
+		t.Fatalf("got %d events, want 4", len(b))
+	}
+	es := make([]pdEvent, len(b))
+	for i := range b {
+		if err := json.Unmarshal(b[i], &es[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := es[0]; e.EventAction != "trigger" || e.DedupKey != "goping/a1/router:pmtu" || e.Payload.Class != "pmtu" ||
+		e.Payload.Severity != "warning" || e.Payload.Summary != "site1 router (10.0.0.1) path MTU 1400, expected 1500" {
+		t.Errorf("pmtu trigger = %s", b[0])
+	}
+	if e := es[1]; e.EventAction != "resolve" || e.DedupKey != "goping/a1/router:pmtu" {
+		t.Errorf("pmtu resolve = %s", b[1])
+	}
+	if e := es[2]; e.EventAction != "trigger" || e.DedupKey != "goping/a1/router" ||
+		e.Payload.CustomDetails["traceroute"] != "1 10.0.0.254 1ms\n" {
+		t.Errorf("trace = %s", b[2])
+	}
+	if e := es[3]; e.EventAction != "trigger" || e.DedupKey != "goping/a1" || e.Payload.Class != "group" ||
+		e.Payload.Summary != "site1 is down: 4/4 hosts down" {
+		t.Errorf("group = %s", b[3])
+	}
+}
+
+func TestPDSeverity(t *testing.T) {
+	for in, want := range map[string]string{"": "error", "info": "info", "warning": "warning", "critical": "critical", "x": "error"} {
+		if got := pdSeverity(in); got != want {
+			t.Errorf("pdSeverity(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+//解析Alertmanager请求中的报警
+func amAlerts(t *testing.T, b []byte) []*amAlert {
+	var alerts []*amAlert
+	if err := json.Unmarshal(b, &alerts); err != nil {
+		t.Fatal(err)
+	}
+	return alerts
+}
+
+func TestAlertmanager(t *testing.T) {
+	s := newTestServer(t, http.StatusOK, "")
+	n := newAlertmanagerNotifier(&NotifierConfig{Name: "am", URL: s.URL, GeneratorURL: "http://goping/"})
+
+	down := testEvent(stateDown)
+	up := testEvent(stateUp)
+	up.Transitions[0].Suppressed = true
+	//没有发送过报警的主机，被抑制的恢复不发送
+	other := testEvent(stateUp)
+	other.Transitions[0].Key = "a1/switch"
+	other.Transitions[0].Suppressed = true
+	for _, ev := range []*Event{down, other, up} {
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := s.all()
+	if len(b) != 2 {
+		t.Fatalf("got %d posts, want 2", len(b))
+	}
+	firing, resolved := amAlerts(t, b[0]), amAlerts(t, b[1])
+	if len(firing) != 1 || len(resolved) != 1 {
+		t.Fatalf("firing = %s, resolved = %s", b[0], b[1])
+	}
+	f, r := firing[0], resolved[0]
+	if f.Labels["alertname"] != "HostDown" || f.Labels["dedup_key"] != "goping/a1/router" ||
+		f.Labels["severity"] != "critical" || f.GeneratorURL != "http://goping/" {
+		t.Errorf("firing = %s", b[0])
+	}
+	if !f.StartsAt.Equal(down.Transitions[0].DownSince) || !f.EndsAt.After(time.Now()) {
+		t.Errorf("firing startsAt %s endsAt %s", f.StartsAt, f.EndsAt)
+	}
+	//恢复使用离线时的标签和开始时间
+	if r.Labels["dedup_key"] != f.Labels["dedup_key"] || !r.StartsAt.Equal(f.StartsAt) || r.EndsAt.After(time.Now()) {
+		t.Errorf("resolved = %s", b[1])
+	}
+	if len(n.firing) != 0 {
+		t.Errorf("firing = %v, want empty", n.firing)
+	}
+}
+
+func TestAlertmanagerNotices(t *testing.T) {
+	s := newTestServer(t, http.StatusOK, "")
+	n := newAlertmanagerNotifier(&NotifierConfig{Name: "am", URL: s.URL})
+
+	down := testEvent(stateDown)
+	trace := testEvent(stateDown)
+	trace.Transitions[0].Kind, trace.Transitions[0].From = noticeTrace, stateDown
+	trace.Transitions[0].Trace = &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}}}
+	low := testPMTUEvent()
+	partial := &Transition{Kind: noticeGroup, Key: "a1", Name: "site1", Area: "a1", Group: "site1",
+		From: groupUp, To: groupPartial, Health: &groupHealth{Down: 1, Total: 4}}
+	gdown := *partial
+	gdown.From, gdown.To, gdown.Health = groupPartial, groupDown, &groupHealth{Down: 4, Total: 4}
+	for _, ev := range []*Event{down, trace, low, {Transitions: []*Transition{partial}}, {Transitions: []*Transition{&gdown}}} {
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := s.all()
+	if len(b) != 5 {
+		t.Fatalf("got %d posts, want 5", len(b))
+	}
+	//路由跟踪结果更新主机的报警，开始时间不变
+	f, tr := amAlerts(t, b[0])[0], amAlerts(t, b[1])[0]
+	if tr.Labels["dedup_key"] != f.Labels["dedup_key"] || !tr.StartsAt.Equal(f.StartsAt) ||
+		tr.Annotations["traceroute"] != "1 10.0.0.254 1ms\n" {
+		t.Errorf("trace = %s", b[1])
+	}
+	if a := amAlerts(t, b[2])[0]; a.Labels["alertname"] != "PathMTULow" || a.Labels["dedup_key"] != "goping/a1/router:pmtu" ||
+		!a.EndsAt.After(time.Now()) {
+		t.Errorf("pmtu = %s", b[2])
+	}
+	if a := amAlerts(t, b[3])[0]; a.Labels["alertname"] != "GroupPartial" || a.Labels["dedup_key"] != "goping/a1" {
+		t.Errorf("group partial = %s", b[3])
+	}
+	//组从部分离线变为离线时结束之前的报警
+	as := amAlerts(t, b[4])
+	if len(as) != 2 || as[0].Labels["alertname"] != "GroupPartial" || as[0].EndsAt.After(time.Now()) ||
+		as[1].Labels["alertname"] != "GroupDown" {
+		t.Errorf("group down = %s", b[4])
+	}
+	if len(n.firing) != 3 {
+		t.Errorf("firing = %v, want host, pmtu and group", n.firing)
+	}
+}
+
+func TestSuppressedRecovery(t *testing.T) {
+	m := &monitor{
+		mail:   make(chan *Transition, 2),
+		logger: log.New(ioutil.Discard, "", 0),
+		cfg:    &Config{},
+		rules:  newRuleSet(""),
+	}
+	h := &Host{Name: "router", AreaID: "a1", Stat: true, State: stateUp, Maintenance: true}
+	//维护中的恢复只发送给保存报警状态的渠道
+	if m.notify(h, stateDown) || len(m.mail) != 1 {
+		t.Fatalf("%d transitions", len(m.mail))
+	}
+	if tr := <-m.mail; !tr.Suppressed || tr.From != stateDown || tr.To != stateUp {
+		t.Errorf("transition %+v", tr)
+	}
+	//被抑制的离线不发送
+	h.Stat, h.State = false, stateDown
+	if m.notify(h, stateUp) || len(m.mail) != 0 {
+		t.Errorf("%d transitions for suppressed down", len(m.mail))
+	}
+
+	mail, pd := &fakeNotifier{events: make(chan *Event, 1)}, &fakeStateful{fakeNotifier{events: make(chan *Event, 1)}}
+	m.channels = map[string][]*channel{"a1": {{"mail", mail}, {"pd", pd}}}
+	all := &Event{Transitions: []*Transition{{Suppressed: true}}}
+	m.dispatch("a1", nil, all)
+	if ev := pd.wait(t); ev != all {
+		t.Errorf("stateful notifier got %+v", ev)
+	}
+	select {
+	case ev := <-mail.events:
+		t.Errorf("mail notifier got %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+//保存报警状态的测试渠道
+type fakeStateful struct {
+	fakeNotifier
+}
+
+func (n *fakeStateful) stateful() {}
@@ -3,28 +3,43 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"html"
 	"net/mail"
 	"net/smtp"
+	"strings"
 	"time"
 )
 
 func (m *monitor) Notify() {
 	for k, v := range m.cfg.MailResv {
 		m.logger.Printf("[INFO] starting process wait for %s\n", k)
-		go func(name string, ch <-chan *Host) {
+		go func(name string, ch <-chan *Transition) {
 		TOP:
 			for newhost := range ch {
-				var hs = []*Host{newhost}
+				var ts = []*Transition{newhost}
 				for {
 					select {
-					case h := <-ch:
-						hs = append(hs, h)
+					case t := <-ch:
+						ts = append(ts, t)
 					case <-time.After(time.Duration(m.cfg.RelayTime) * time.Second):
-						if err := SendMail(m.cfg.Mail, hs); err != nil {
-							m.logger.Printf("[ERROR] send notify email of %s %s\n", name, err)
-						} else {
-							m.logger.Printf("[INFO] send email of %s ok\n", name)
+						//被抑制的恢复只发送给保存报警状态的渠道
+						var shown []*Transition
+						for _, t := range ts {
+							if !t.Suppressed {
+								shown = append(shown, t)
+							}
 						}
+						var ev, all *Event
+						if len(shown) > 0 {
+							ev = m.newEvent(name, shown)
+							for _, o := range ev.Outages {
+								m.logger.Printf("[EORROR] %s\n", o)
+							}
+						}
+						if len(shown) < len(ts) {
+							all = m.newEvent(name, ts)
+						}
+						m.dispatch(name, ev, all)
 						continue TOP
 					}
 				}
@@ -39,20 +54,68 @@ func (m *monitor) Notify() {
 	}
 }
 
-func SendMail(m Mailer, hs []*Host) error {
+//发送通知邮件: 站点故障合并显示
+func SendMail(m Mailer, ev *Event, rcptTo string) error {
+	return sendHTML(m, mailRcpt(m, ev, rcptTo), ev.Subject, mailBody(ev))
+}
+
+//邮件接收人: rcptTo不为空时只发送给渠道的接收人，否则为全局和组的接收人，
+//以及升级策略和报警规则指定的接收人；组状态通知规则指定接收人时只发送给规则的接收人
+func mailRcpt(m Mailer, ev *Event, rcptTo string) string {
+	if rcptTo != "" {
+		return rcptTo
+	}
+	//组状态通知规则指定的接收人
+	if t := ev.Transitions[0]; t.Kind == noticeGroup && len(t.Rcpt) > 0 {
+		return strings.Join(t.Rcpt, ",")
+	}
+	rcpt := m.RcptTo
+	if m.Emails[ev.Area] != "" {
+		rcpt = rcpt + "," + m.Emails[ev.Area]
+	}
+	for _, t := range ev.Transitions {
+		rcpt = addRcpt(rcpt, append(append([]string{}, t.Escalated...), t.Rcpt...))
+	}
+	return rcpt
+}
+
+//增加接收人: to中每项可以是逗号分隔的多个地址，按邮件地址去重，忽略错误的地址
+func addRcpt(rcpt string, to []string) string {
+	seen := make(map[string]bool)
+	if list, err := mail.ParseAddressList(rcpt); err == nil {
+		for _, a := range list {
+			seen[strings.ToLower(a.Address)] = true
+		}
+	}
+	for _, s := range to {
+		list, err := mail.ParseAddressList(s)
+		if err != nil {
+			continue
+		}
+		for _, a := range list {
+			key := strings.ToLower(a.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if rcpt == "" {
+				rcpt = a.Address
+			} else {
+				rcpt = rcpt + "," + a.Address
+			}
+		}
+	}
+	return rcpt
+}
+
+//发送HTML格式的邮件: rcpt为逗号分隔的接收人
+func sendHTML(m Mailer, rcpt, subject, body string) error {
 	b64 := base64.StdEncoding
 
 	from, err := mail.ParseAddress(m.MailFrom)
 	if err != nil {
 		return err
 	}
-
-	rcpt := m.RcptTo
-	h1 := hs[0]
-
-	if m.Emails[h1.AreaID] != "" {
-		rcpt = rcpt + "," + m.Emails[h1.AreaID]
-	}
 	rcpts, err := mail.ParseAddressList(rcpt)
 	if err != nil {
 		return err
@@ -66,26 +129,8 @@ func SendMail(m Mailer, hs []*Host) error {
 	header := make(map[string]string)
 	header["From"] = from.String()
 	header["To"] = rcpt
-
-	var body string
-	var format = "2006-01-02 15:04:05"
-
-	for i, v := range hs {
-		status := `<span style="color: red;">离线</span>`
-		if v.Stat {
-			status := `<span style="color: green;">上线</span>`
-			body += fmt.Sprintf(`<div>%d、%s：%s %s<br /> 恢复时间: %s</div>`,
-				i+1, v.Name, v.Addr, status, v.Last.Format(format))
-		} else {
-			body += fmt.Sprintf(`<div>%d、%s：%s %s<br /> 最近在线: %s</div>`,
-				i+1, v.Name, v.Addr, status, v.Last.Format(format))
-		}
-	}
-
-	subject := "网络设备状态变化通知"
 	//utf8
-	header["Subject"] = fmt.Sprintf("=?UTF-8?B?%s?=",
-		b64.EncodeToString([]byte(fmt.Sprintf("[%s] %s - %s", h1.AreaID, h1.Area, subject))))
+	header["Subject"] = fmt.Sprintf("=?UTF-8?B?%s?=", b64.EncodeToString([]byte(subject)))
 	header["MIME-Version"] = "1.0"
 	header["Content-Type"] = "text/html; charset=UTF-8"
 	header["Content-Transfer-Encoding"] = "base64"
@@ -116,3 +161,90 @@ func SendMail(m Mailer, hs []*Host) error {
 	}
 	return nil
 }
+
+//单独发送的通知类型
+const (
+	noticePMTU  = "pmtu"
+	noticeTrace = "trace"
+	noticeGroup = "group"
+)
+
+var noticeSubjects = map[string]string{
+	noticePMTU:  "路径MTU变化通知",
+	noticeTrace: "路由跟踪结果通知",
+	noticeGroup: "区域状态变化通知",
+}
+
+//邮件内容: 站点故障合并显示；路径MTU变化和路由跟踪结果单独显示，不显示为上线
+func mailBody(ev *Event) string {
+	var body string
+	var format = "2006-01-02 15:04:05"
+
+	for _, o := range ev.Outages {
+		body += fmt.Sprintf(`<div style="color: red;">站点故障: %s %d/%d 台离线 (%d%%)</div>`,
+			html.EscapeString(o.Name), len(o.Down), o.Total, len(o.Down)*100/o.Total)
+		var list string
+		for _, v := range o.Down {
+			list += fmt.Sprintf("%s\t%s\t最近在线: %s\n", v.Name, v.Address, v.Last.Format(format))
+		}
+		body += fmt.Sprintf(`<pre>%s</pre>`, html.EscapeString(list))
+	}
+
+	for i, v := range ev.rest() {
+		switch v.Kind {
+		case noticePMTU:
+			status := `<span style="color: green;">路径MTU恢复</span>`
+			if v.PMTU < v.PMTUExpect {
+				status = `<span style="color: red;">路径MTU过低</span>`
+			}
+			body += fmt.Sprintf(`<div>%d、%s：%s %s<br /> 路径MTU: %d (期望 %d)</div>`,
+				i+1, v.Name, v.Address, status, v.PMTU, v.PMTUExpect)
+		case noticeGroup:
+			gh := v.Health
+			color := map[string]string{groupUp: "green", groupPartial: "darkorange", groupDown: "red"}[v.To]
+			body += fmt.Sprintf(`<div>%s：<span style="color: %s;">%s</span> (之前: %s)<br /> 在线 %d，离线 %d，总数 %d<br /> 时间: %s</div>`,
+				html.EscapeString(v.Name), color, v.To, v.From, gh.Up, gh.Down, gh.Total, v.Last.Format(format))
+		case noticeTrace:
+			status := `<span style="color: red;">离线</span>`
+			body += fmt.Sprintf(`<div>%d、%s：%s %s<br /> 最近在线: %s</div>`,
+				i+1, v.Name, v.Address, status, v.Last.Format(format))
+			if v.Trace != nil {
+				body += fmt.Sprintf(`<div>路由跟踪: <pre>%s</pre></div>`,
+					html.EscapeString(v.Trace.String()))
+			}
+		default:
+			status := `<span style="color: red;">离线</span>`
+			if v.up() {
+				status := `<span style="color: green;">上线</span>`
+				body += fmt.Sprintf(`<div>%d、%s：%s %s<br /> 恢复时间: %s</div>`,
+					i+1, v.Name, v.Address, status, v.Last.Format(format))
+				//离线持续时间
+				if !v.DownSince.IsZero() && v.UpSince.After(v.DownSince) {
+					body += fmt.Sprintf(`<div>离线 %s (%s 至 %s)</div>`,
+						v.UpSince.Sub(v.DownSince).Truncate(time.Second),
+						v.DownSince.Format(format), v.UpSince.Format(format))
+				}
+			} else {
+				body += fmt.Sprintf(`<div>%d、%s：%s %s<br /> 最近在线: %s</div>`,
+					i+1, v.Name, v.Address, status, v.Last.Format(format))
+				if v.Remind > 0 {
+					body += fmt.Sprintf(`<div>第 %d 次提醒，已离线 %s</div>`,
+						v.Remind, time.Since(v.AlertAt).Truncate(time.Minute))
+				}
+				//因为本设备离线而不可达的下级设备
+				if len(v.Unreachable) > 0 {
+					body += fmt.Sprintf(`<div>下游不可达 %d 台: %s</div>`,
+						len(v.Unreachable), html.EscapeString(strings.Join(v.Unreachable, ", ")))
+				}
+			}
+			if v.PMTUExpect > 0 && v.PMTU > 0 {
+				mtu := fmt.Sprintf(`<span style="color: green;">%d</span>`, v.PMTU)
+				if v.PMTU < v.PMTUExpect {
+					mtu = fmt.Sprintf(`<span style="color: red;">%d</span>`, v.PMTU)
+				}
+				body += fmt.Sprintf(`<div>路径MTU: %s (期望 %d)</div>`, mtu, v.PMTUExpect)
+			}
+		}
+	}
+	return body
+}
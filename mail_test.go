@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailBodyPMTU(t *testing.T) {
+	m := &monitor{cfg: &Config{}}
+	h := &Host{Name: "r1", Addr: "10.0.0.1", AreaID: "a1", Area: "site1", Stat: true, State: stateUp,
+		Last: time.Now(), PMTU: 1400, PMTUExpect: 1500}
+	pmtu := newTransition(h, stateUp)
+	pmtu.Kind = noticePMTU
+	ev := m.newEvent("a1", []*Transition{pmtu})
+	if ev.Subject != "[a1] site1 - 路径MTU变化通知" {
+		t.Errorf("subject %q", ev.Subject)
+	}
+	body := mailBody(ev)
+	if !strings.Contains(body, "路径MTU过低") || !strings.Contains(body, "路径MTU: 1400 (期望 1500)") {
+		t.Errorf("body %q", body)
+	}
+	//路径MTU变化不是主机恢复
+	if strings.Contains(body, "上线") || strings.Contains(body, "恢复时间") {
+		t.Errorf("pmtu notice rendered as recovery: %q", body)
+	}
+
+	pmtu.PMTU = 1500
+	if body = mailBody(ev); !strings.Contains(body, "路径MTU恢复") {
+		t.Errorf("body %q", body)
+	}
+
+	//和状态变化一起发送时使用状态变化的主题
+	down := newTransition(&Host{Name: "r2", Addr: "10.0.0.2", AreaID: "a1", Area: "site1", State: stateDown}, stateUp)
+	ev = m.newEvent("a1", []*Transition{pmtu, down})
+	if body = mailBody(ev); ev.Subject != "[a1] site1 - 网络设备状态变化通知" || !strings.Contains(body, "离线") {
+		t.Errorf("mixed: subject %q body %q", ev.Subject, body)
+	}
+}
+
+func TestMailRcpt(t *testing.T) {
+	m := Mailer{RcptTo: "noc@example.com", Emails: map[string]string{"a1": "a1@example.com"}}
+	ev := &Event{Area: "a1", Transitions: []*Transition{
+		{Escalated: []string{"A1@example.com", "noc@example.com", "manager@example.com"},
+			Rcpt: []string{"oncall@example.com, manager@example.com"}},
+		{Rcpt: []string{"oncall@example.com"}},
+	}}
+	//升级后的接收人增加到默认接收人之后并去重
+	want := "noc@example.com,a1@example.com,manager@example.com,oncall@example.com"
+	if rcpt := mailRcpt(m, ev, ""); rcpt != want {
+		t.Errorf("mailRcpt = %s, want %s", rcpt, want)
+	}
+	//渠道指定的接收人
+	if rcpt := mailRcpt(m, ev, "ops@example.com"); rcpt != "ops@example.com" {
+		t.Errorf("channel rcpt = %s", rcpt)
+	}
+}
+
+func TestMailBodyDuration(t *testing.T) {
+	down := time.Date(2026, 10, 18, 8, 0, 0, 0, time.Local)
+	h := &Host{Name: "r1", Addr: "10.0.0.1", Stat: true, State: stateUp, Last: down.Add(90 * time.Minute),
+		DownSince: down, UpSince: down.Add(90 * time.Minute)}
+	ev := &Event{Transitions: []*Transition{newTransition(h, stateDown)}}
+	if body := mailBody(ev); !strings.Contains(body, "离线 1h30m0s (2026-10-18 08:00:00 至 2026-10-18 09:30:00)") {
+		t.Errorf("body %q", body)
+	}
+	b, err := json.Marshal(h)
+	if err != nil || !strings.Contains(string(b), `"duration":"`) || !strings.Contains(string(b), `"up_since":`) {
+		t.Errorf("json %s, %v", b, err)
+	}
+}
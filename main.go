@@ -92,6 +92,12 @@ func main() {
 	}
 
 	cfg := ConfigReader(jcfg)
+	//报警规则目录
+	cfg.RulesDir = filepath.Join(baseDir, "etc", "rules")
+	//维护窗口
+	cfg.MaintenanceFile = filepath.Join(baseDir, "etc", "maintenance.json")
+	//SNMPv3的snmpEngineBoots
+	cfg.SNMPDir = filepath.Join(baseDir, "etc", "snmp")
 	argv := flag.Args()
 	if len(argv) < 1 {
 		fmt.Println("argv less than one")
@@ -137,7 +143,7 @@ func main() {
 		}
 
 		//启动http服务
-		srv.Listen(*httpAddr, jcfg, httpLog, *port, conf, groupDir, pingLogFilePath, httpLogFile)
+		srv.Listen(*httpAddr, jcfg, httpLog, *port, conf, groupDir, pingLogFilePath, httpLogFile, cfg.MaintenanceFile)
 
 	case "run":
 		var pingLogFile = filepath.Join(logDir, "ping_"+date+".log")
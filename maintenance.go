@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+//维护窗口的时间格式
+const maintenanceFormat = "2006-01-02 15:04"
+
+//维护窗口: 窗口内的主机照常探测，状态显示为维护中，不发送通知
+type Maintenance struct {
+	ID      string `json:"id"`
+	Comment string `json:"comment,omitempty"`
+	//目标: 主机(area/name)、组(area)或者标签
+	Hosts  []string `json:"hosts,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	//一次性窗口
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	//重复窗口: 星期(0为周日)和时间范围，如"22:00-06:00"，结束早于开始时跨过午夜
+	Weekdays []int  `json:"weekdays,omitempty"`
+	Time     string `json:"time,omitempty"`
+	//时区，如Asia/Shanghai，默认为本地时区
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func (w *Maintenance) location() (*time.Location, error) {
+	if w.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(w.Timezone)
+}
+
+//解析"HH:MM-HH:MM"，返回从零点开始的分钟数
+func parseTimeRange(s string) (int, int, error) {
+	var h1, m1, h2, m2 int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil {
+		return 0, 0, fmt.Errorf("time %q: want HH:MM-HH:MM", s)
+	}
+	if h1 < 0 || h1 > 23 || h2 < 0 || h2 > 24 || m1 < 0 || m1 > 59 || m2 < 0 || m2 > 59 {
+		return 0, 0, fmt.Errorf("time %q out of range", s)
+	}
+	return h1*60 + m1, h2*60 + m2, nil
+}
+
+//检查维护窗口配置
+func (w *Maintenance) check() error {
+	if w.ID == "" {
+		return errors.New("maintenance id is empty")
+	}
+	if len(w.Hosts)+len(w.Groups)+len(w.Tags) == 0 {
+		return fmt.Errorf("maintenance %s: no hosts, groups or tags", w.ID)
+	}
+	loc, err := w.location()
+	if err != nil {
+		return fmt.Errorf("maintenance %s: %s", w.ID, err)
+	}
+	if w.Time != "" {
+		if _, _, err := parseTimeRange(w.Time); err != nil {
+			return fmt.Errorf("maintenance %s: %s", w.ID, err)
+		}
+		for _, d := range w.Weekdays {
+			if d < 0 || d > 6 {
+				return fmt.Errorf("maintenance %s: weekday %d", w.ID, d)
+			}
+		}
+		return nil
+	}
+	start, err := time.ParseInLocation(maintenanceFormat, w.Start, loc)
+	if err != nil {
+		return fmt.Errorf("maintenance %s: start %s", w.ID, err)
+	}
+	end, err := time.ParseInLocation(maintenanceFormat, w.End, loc)
+	if err != nil {
+		return fmt.Errorf("maintenance %s: end %s", w.ID, err)
+	}
+	if !end.After(start) {
+		return fmt.Errorf("maintenance %s: end before start", w.ID)
+	}
+	return nil
+}
+
+//t是否在维护窗口内
+func (w *Maintenance) Active(t time.Time) bool {
+	loc, err := w.location()
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	if w.Time == "" {
+		start, err1 := time.ParseInLocation(maintenanceFormat, w.Start, loc)
+		end, err2 := time.ParseInLocation(maintenanceFormat, w.End, loc)
+		return err1 == nil && err2 == nil && !t.Before(start) && t.Before(end)
+	}
+
+	from, to, err := parseTimeRange(w.Time)
+	if err != nil {
+		return false
+	}
+	length := to - from
+	if length <= 0 {
+		length += 24 * 60
+	}
+	//窗口可能从今天或者昨天开始
+	for _, days := range []int{0, -1} {
+		day := time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, loc)
+		if len(w.Weekdays) > 0 && !containsInt(w.Weekdays, int(day.Weekday())) {
+			continue
+		}
+		start := day.Add(time.Duration(from) * time.Minute)
+		end := start.Add(time.Duration(length) * time.Minute)
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+//一次性窗口已经结束
+func (w *Maintenance) expired(t time.Time) bool {
+	if w.Time != "" {
+		return false
+	}
+	loc, err := w.location()
+	if err != nil {
+		return false
+	}
+	end, err := time.ParseInLocation(maintenanceFormat, w.End, loc)
+	return err == nil && !t.Before(end)
+}
+
+//主机是否是维护窗口的目标
+func (w *Maintenance) match(h *Host) bool {
+	if contains(w.Hosts, hostKey(h.AreaID, h.Name)) || contains(w.Groups, h.AreaID) {
+		return true
+	}
+	for _, tag := range h.Tags {
+		if contains(w.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+//读取维护窗口，文件不存在时返回空列表
+func ReadMaintenance(file string) ([]*Maintenance, error) {
+	var list = make([]*Maintenance, 0)
+	bs, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &list); err != nil {
+		return nil, err
+	}
+	for _, w := range list {
+		if err := w.check(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+//维护窗口文件有变化时重新读取，文件删除时清除全部窗口，并更新主机的维护状态；
+//窗口结束时仍然离线并且没有发送过离线通知的主机发送通知
+func (m *monitor) updateMaintenance() {
+	if fi, err := os.Stat(m.cfg.MaintenanceFile); err == nil && !fi.ModTime().Equal(m.maintMtime) {
+		list, err := ReadMaintenance(m.cfg.MaintenanceFile)
+		if err != nil {
+			m.logger.Printf("[ERROR] read maintenance %s: %s\n", m.cfg.MaintenanceFile, err)
+		} else {
+			m.maintenance = list
+			m.logger.Printf("[INFO] maintenance loaded: %d windows\n", len(list))
+		}
+		m.maintMtime = fi.ModTime()
+	} else if os.IsNotExist(err) && !m.maintMtime.IsZero() {
+		m.maintenance, m.maintMtime = nil, time.Time{}
+		m.logger.Printf("[INFO] maintenance %s removed\n", m.cfg.MaintenanceFile)
+	}
+
+	now := time.Now()
+	for _, h := range m.cfg.Hosts {
+		var in bool
+		for _, w := range m.maintenance {
+			if w.match(h) && w.Active(now) {
+				in = true
+				break
+			}
+		}
+		if in == h.Maintenance {
+			continue
+		}
+		m.logger.Printf("[INFO] %s, maintenance %v\n", h, in)
+		h.Maintenance = in
+		if !in && h.maintDown && h.State == stateDown && m.notify(h, stateUp) {
+			//路由跟踪还没有完成时，完成后发送跟踪结果
+			if _, ok := m.tracing[h]; ok {
+				m.tracing[h] = true
+			}
+		}
+	}
+}
+
+//解析逗号分隔的列表
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
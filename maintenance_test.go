@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceActive(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation(maintenanceFormat, s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	once := &Maintenance{Start: "2026-10-18 22:00", End: "2026-10-19 02:00", Timezone: "UTC"}
+	//2026-10-18为周日，跨过午夜的窗口在周一凌晨仍然有效
+	nightly := &Maintenance{Weekdays: []int{0}, Time: "22:00-06:00", Timezone: "UTC"}
+	daily := &Maintenance{Time: "09:00-10:30", Timezone: "UTC"}
+	for _, tc := range []struct {
+		w    *Maintenance
+		t    string
+		want bool
+	}{
+		{once, "2026-10-18 21:59", false},
+		{once, "2026-10-18 22:00", true},
+		{once, "2026-10-19 01:59", true},
+		{once, "2026-10-19 02:00", false},
+		{nightly, "2026-10-18 21:00", false},
+		{nightly, "2026-10-18 23:00", true},
+		{nightly, "2026-10-19 05:59", true},
+		{nightly, "2026-10-19 06:00", false},
+		{nightly, "2026-10-19 23:00", false},
+		{nightly, "2026-10-17 23:00", false},
+		{daily, "2026-10-20 09:00", true},
+		{daily, "2026-10-20 10:29", true},
+		{daily, "2026-10-20 10:30", false},
+	} {
+		if got := tc.w.Active(at(tc.t)); got != tc.want {
+			t.Errorf("%+v at %s: active = %v, want %v", tc.w, tc.t, got, tc.want)
+		}
+	}
+
+	//时区: 08:00 UTC为16:00 +08:00
+	w := &Maintenance{Time: "15:00-17:00", Timezone: "Asia/Shanghai"}
+	if _, err := w.location(); err == nil && !w.Active(at("2026-10-20 08:00")) {
+		t.Errorf("%+v: want active at 08:00 UTC", w)
+	}
+
+	if once.expired(at("2026-10-19 01:00")) || !once.expired(at("2026-10-19 02:00")) || nightly.expired(at("2030-01-01 00:00")) {
+		t.Error("expired")
+	}
+}
+
+func TestMaintenanceCheck(t *testing.T) {
+	for _, tc := range []struct {
+		w  Maintenance
+		ok bool
+	}{
+		{Maintenance{ID: "m1", Hosts: []string{"a1/sw1"}, Start: "2026-10-18 22:00", End: "2026-10-19 02:00"}, true},
+		{Maintenance{ID: "m1", Tags: []string{"core"}, Weekdays: []int{0, 6}, Time: "22:00-06:00"}, true},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Time: "00:00-24:00"}, true},
+		{Maintenance{Hosts: []string{"a1/sw1"}, Time: "22:00-06:00"}, false},
+		{Maintenance{ID: "m1", Time: "22:00-06:00"}, false},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Time: "25:00-06:00"}, false},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Time: "22:00"}, false},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Weekdays: []int{7}, Time: "22:00-06:00"}, false},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Start: "2026-10-19 02:00", End: "2026-10-18 22:00"}, false},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Start: "2026-10-18", End: "2026-10-19"}, false},
+		{Maintenance{ID: "m1", Groups: []string{"a1"}, Time: "22:00-06:00", Timezone: "Nowhere/City"}, false},
+	} {
+		if err := tc.w.check(); (err == nil) != tc.ok {
+			t.Errorf("%+v: err = %v", tc.w, err)
+		}
+	}
+}
+
+func TestMaintenanceMatch(t *testing.T) {
+	h := &Host{Name: "sw1", AreaID: "a1", Tags: []string{"edge"}}
+	for _, tc := range []struct {
+		w    *Maintenance
+		want bool
+	}{
+		{&Maintenance{Hosts: []string{"a1/sw1"}}, true},
+		{&Maintenance{Hosts: []string{"a2/sw1"}}, false},
+		{&Maintenance{Groups: []string{"a1"}}, true},
+		{&Maintenance{Tags: []string{"core", "edge"}}, true},
+		{&Maintenance{Tags: []string{"core"}}, false},
+	} {
+		if got := tc.w.match(h); got != tc.want {
+			t.Errorf("%+v: match = %v, want %v", tc.w, got, tc.want)
+		}
+	}
+}
+
+func TestReadMaintenance(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "maintenance.json")
+	//文件不存在时没有窗口
+	if ws, err := ReadMaintenance(file); err != nil || len(ws) != 0 {
+		t.Fatalf("ReadMaintenance = %v, %v, want empty", ws, err)
+	}
+	if err := ioutil.WriteFile(file, []byte(`[{"id": "m1", "groups": ["a1"], "time": "22:00-06:00"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := ReadMaintenance(file)
+	if err != nil || len(ws) != 1 || ws[0].ID != "m1" {
+		t.Fatalf("ReadMaintenance = %v, %v", ws, err)
+	}
+	if err := ioutil.WriteFile(file, []byte(`[{"id": "m1", "groups": ["a1"], "time": "22:00"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMaintenance(file); err == nil {
+		t.Error("want error for invalid window")
+	}
+}
+
+func TestUpdateMaintenanceEnd(t *testing.T) {
+	down := &Host{Name: "web1", AreaID: "a1", State: stateUp, Stat: true}
+	m := &monitor{
+		mail:    make(chan *Transition, 2),
+		logger:  log.New(ioutil.Discard, "", 0),
+		cfg:     &Config{Hosts: []*Host{down}, MaintenanceFile: filepath.Join(t.TempDir(), "maintenance.json")},
+		rules:   newRuleSet(""),
+		tracing: map[*Host]bool{down: false},
+	}
+	window := []*Maintenance{{
+		Hosts: []string{"a1/web1"},
+		Start: time.Now().Add(-time.Hour).Format(maintenanceFormat),
+		End:   time.Now().Add(time.Hour).Format(maintenanceFormat),
+	}}
+	m.maintenance = window
+	m.updateMaintenance()
+	//维护期间离线不通知
+	down.Stat, down.State = false, stateDown
+	if m.notify(down, stateUp) || len(m.mail) != 0 {
+		t.Fatal("notified in maintenance")
+	}
+	//窗口结束时仍然离线则发送离线通知，路由跟踪完成后发送跟踪结果
+	m.maintenance = nil
+	m.updateMaintenance()
+	if down.Maintenance || len(m.mail) != 1 || !m.tracing[down] {
+		t.Errorf("maintenance %v, %d mails, tracing %v after window ended", down.Maintenance, len(m.mail), m.tracing[down])
+	}
+	//已经通知过的离线不再重复通知
+	m.maintenance = window
+	m.updateMaintenance()
+	m.maintenance = nil
+	m.updateMaintenance()
+	if len(m.mail) != 1 {
+		t.Errorf("%d mails, want 1", len(m.mail))
+	}
+}
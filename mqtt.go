@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMQTTPrefix    = "goping"
+	defaultMQTTKeepAlive = 60
+	//重连的最大间隔
+	mqttMaxBackoff = time.Minute
+	//等待发送的消息数，超过时丢弃
+	mqttQueue = 1024
+	//队列满时警告日志的最小间隔
+	mqttDropWarn = time.Minute
+)
+
+//MQTT发布配置: broker格式为tcp://host:1883或者tls://host:8883
+type MQTTConfig struct {
+	Broker   string `json:"broker"`
+	ClientID string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	//主题前缀，默认goping
+	Prefix string `json:"prefix,omitempty"`
+	//同时发布每次探测的结果
+	Results bool `json:"results,omitempty"`
+	//心跳间隔(秒)，默认60
+	KeepAlive int `json:"keepalive,omitempty"`
+}
+
+//检查MQTT配置
+func (c *MQTTConfig) check() error {
+	if _, _, err := c.address(); err != nil {
+		return err
+	}
+	if c.KeepAlive < 0 || c.KeepAlive > 65535 {
+		return fmt.Errorf("mqtt keepalive %d", c.KeepAlive)
+	}
+	return nil
+}
+
+//broker的地址和是否使用TLS
+func (c *MQTTConfig) address() (string, bool, error) {
+	addr, useTLS := c.Broker, false
+	switch {
+	case strings.HasPrefix(addr, "tcp://"), strings.HasPrefix(addr, "mqtt://"):
+		addr = addr[strings.Index(addr, "://")+3:]
+	case strings.HasPrefix(addr, "tls://"), strings.HasPrefix(addr, "ssl://"), strings.HasPrefix(addr, "mqtts://"):
+		addr, useTLS = addr[strings.Index(addr, "://")+3:], true
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", false, fmt.Errorf("mqtt broker %q: %s", c.Broker, err)
+	}
+	return addr, useTLS, nil
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+	//进入队列的时间: 早于当前连接的消息不再发送
+	time time.Time
+}
+
+//MQTT 3.1.1客户端: 只支持QoS 0发布，断开后按指数退避重连，
+//重连后重新发布保留的主机状态，断开期间排队的消息不再发送
+type mqttClient struct {
+	cfg    *MQTTConfig
+	logger *log.Logger
+	msgs   chan *mqttMessage
+
+	mu sync.Mutex
+	//保留消息: 主题对应的最新内容
+	retained map[string][]byte
+	//队列满时丢弃的保留消息的主题，队列空闲时重新发布最新内容
+	pending map[string]bool
+	//丢弃的消息数和上次警告的时间
+	dropped  int
+	lastWarn time.Time
+}
+
+func newMQTTClient(cfg *MQTTConfig, l *log.Logger) *mqttClient {
+	c := &mqttClient{
+		cfg:      cfg,
+		logger:   l,
+		msgs:     make(chan *mqttMessage, mqttQueue),
+		retained: make(map[string][]byte),
+		pending:  make(map[string]bool),
+	}
+	go c.run()
+	return c
+}
+
+//发布消息，不阻塞监控循环
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if retain {
+		c.retained[topic] = payload
+	}
+	select {
+	case c.msgs <- &mqttMessage{topic, payload, retain, time.Now()}:
+		return
+	default:
+	}
+	if retain {
+		c.pending[topic] = true
+	}
+	//队列满时按间隔记录丢弃的消息数
+	c.dropped++
+	if time.Since(c.lastWarn) >= mqttDropWarn {
+		c.logger.Printf("[WARN] mqtt queue full, %d messages dropped\n", c.dropped)
+		c.dropped, c.lastWarn = 0, time.Now()
+	}
+}
+
+//取出保留消息: all为true时为全部主题，否则为丢弃过的主题
+func (c *mqttClient) takeRetained(all bool) []*mqttMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var msgs []*mqttMessage
+	for topic, payload := range c.retained {
+		if all || c.pending[topic] {
+			msgs = append(msgs, &mqttMessage{topic, payload, true, time.Now()})
+		}
+	}
+	c.pending = make(map[string]bool)
+	return msgs
+}
+
+func (c *mqttClient) run() {
+	backoff := time.Second
+	for {
+		conn, err := c.connect()
+		if err != nil {
+			c.logger.Printf("[ERROR] mqtt connect %s: %s, retry in %s\n", c.cfg.Broker, err, backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > mqttMaxBackoff {
+				backoff = mqttMaxBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		c.logger.Printf("[INFO] mqtt connected to %s\n", c.cfg.Broker)
+		err = c.serve(conn)
+		conn.Close()
+		c.logger.Printf("[ERROR] mqtt disconnected from %s: %s\n", c.cfg.Broker, err)
+	}
+}
+
+func (c *mqttClient) connect() (net.Conn, error) {
+	addr, useTLS, err := c.cfg.address()
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := c.cfg.ClientID
+	if id == "" {
+		host, _ := os.Hostname()
+		id = fmt.Sprintf("goping-%s-%d", host, os.Getpid())
+	}
+	var flags byte = 0x02 //clean session
+	payload := mqttString(id)
+	if c.cfg.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(c.cfg.Username)...)
+		if c.cfg.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(c.cfg.Password)...)
+		}
+	}
+	keepAlive := c.keepAlive()
+	body := append(mqttString("MQTT"), 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = append(body, payload...)
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	typ, ack, err := readPacket(bufio.NewReader(conn))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if typ>>4 != 2 || len(ack) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet type %d", typ>>4)
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused, code %d", ack[1])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (c *mqttClient) keepAlive() int {
+	if c.cfg.KeepAlive > 0 {
+		return c.cfg.KeepAlive
+	}
+	return defaultMQTTKeepAlive
+}
+
+//发送消息和心跳，直到连接出错
+func (c *mqttClient) serve(conn net.Conn) error {
+	//读取broker的响应，连接断开时返回错误
+	readErr := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(c.keepAlive()) * 3 / 2 * time.Second))
+			if _, _, err := readPacket(r); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	write := func(b []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(b)
+		return err
+	}
+
+	//重新发布保留消息: 之前进入队列的消息已经过期，保留消息的最新内容已经重新发布
+	connected := time.Now()
+	for _, msg := range c.takeRetained(true) {
+		if err := write(publishPacket(msg)); err != nil {
+			return err
+		}
+	}
+
+	ping := time.NewTicker(time.Duration(c.keepAlive()) * time.Second / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			return err
+		case msg := <-c.msgs:
+			if msg.time.Before(connected) {
+				continue
+			}
+			if err := write(publishPacket(msg)); err != nil {
+				return err
+			}
+			//队列空闲时重新发布队列满时丢弃的保留消息
+			if len(c.msgs) == 0 {
+				for _, msg := range c.takeRetained(false) {
+					if err := write(publishPacket(msg)); err != nil {
+						return err
+					}
+				}
+			}
+		case <-ping.C:
+			if err := write([]byte{0xc0, 0}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//MQTT字符串: 两字节长度和内容
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+//固定头和剩余长度
+func mqttPacket(typ byte, body []byte) []byte {
+	b := []byte{typ}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+//QoS 0的PUBLISH
+func publishPacket(msg *mqttMessage) []byte {
+	var typ byte = 0x30
+	if msg.retain {
+		typ |= 0x01
+	}
+	return mqttPacket(typ, append(mqttString(msg.topic), msg.payload...))
+}
+
+//读取一个控制报文，返回类型和内容
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift int
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(d&0x7f) << shift
+		shift += 7
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+//主题中不能包含+、#和/的名称
+func topicName(s string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+//主机状态: prefix/area/name/state，保留消息
+func (m *monitor) publishState(h *Host) {
+	if m.mqtt == nil {
+		return
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"name":       h.Name,
+		"address":    h.Addr,
+		"area":       h.AreaID,
+		"group":      h.Area,
+		"state":      h.State,
+		"status":     h.Stat,
+		"rtt":        h.RTT,
+		"last":       h.Last,
+		"down_since": h.DownSince,
+		"up_since":   h.UpSince,
+		"time":       time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	m.mqtt.publish(m.topic(h, "state"), b, true)
+}
+
+//探测结果: prefix/area/name/rtt，单位毫秒，-1表示超时
+func (m *monitor) publishResult(h *Host, rtt time.Duration) {
+	if m.mqtt == nil || !m.cfg.MQTT.Results {
+		return
+	}
+	v := "-1"
+	if rtt >= 0 {
+		v = fmt.Sprintf("%.3f", float64(rtt)/float64(time.Millisecond))
+	}
+	m.mqtt.publish(m.topic(h, "rtt"), []byte(v), false)
+}
+
+func (m *monitor) topic(h *Host, name string) string {
+	prefix := m.cfg.MQTT.Prefix
+	if prefix == "" {
+		prefix = defaultMQTTPrefix
+	}
+	return strings.Join([]string{prefix, topicName(h.AreaID), topicName(h.Name), name}, "/")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMQTTRemainingLength(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	} {
+		body := make([]byte, tc.n)
+		p := mqttPacket(0x30, body)
+		if p[0] != 0x30 || !bytes.Equal(p[1:1+len(tc.want)], tc.want) || len(p) != 1+len(tc.want)+tc.n {
+			t.Errorf("length %d: header % x, want % x", tc.n, p[1:1+len(tc.want)], tc.want)
+			continue
+		}
+		typ, got, err := readPacket(bufio.NewReader(bytes.NewReader(p)))
+		if err != nil || typ != 0x30 || len(got) != tc.n {
+			t.Errorf("length %d: readPacket = %x, %d, %v", tc.n, typ, len(got), err)
+		}
+	}
+}
+
+func TestReadPacketError(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{0x20},
+		//剩余长度超过4字节
+		{0x20, 0x80, 0x80, 0x80, 0x80, 0x01},
+		//内容不完整
+		{0x20, 0x02, 0x00},
+	} {
+		if _, _, err := readPacket(bufio.NewReader(bytes.NewReader(b))); err == nil {
+			t.Errorf("readPacket(% x): want error", b)
+		}
+	}
+}
+
+func TestPublishPacket(t *testing.T) {
+	got := publishPacket(&mqttMessage{topic: "a/b", payload: []byte("hi"), retain: true})
+	want := []byte{0x31, 0x07, 0x00, 0x03, 'a', '/', 'b', 'h', 'i'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("retained publish = % x, want % x", got, want)
+	}
+	if got := publishPacket(&mqttMessage{topic: "a"}); got[0] != 0x30 {
+		t.Errorf("publish type %x, want 30", got[0])
+	}
+}
+
+func TestTopicName(t *testing.T) {
+	if got := topicName("a/b+c#d"); got != "a_b_c_d" {
+		t.Errorf("topicName = %q", got)
+	}
+}
+
+func TestMQTTAddress(t *testing.T) {
+	for _, tc := range []struct {
+		broker string
+		addr   string
+		tls    bool
+		err    bool
+	}{
+		{"tcp://127.0.0.1:1883", "127.0.0.1:1883", false, false},
+		{"mqtts://broker:8883", "broker:8883", true, false},
+		{"broker:1883", "broker:1883", false, false},
+		{"tcp://broker", "", false, true},
+	} {
+		addr, useTLS, err := (&MQTTConfig{Broker: tc.broker}).address()
+		if (err != nil) != tc.err || addr != tc.addr || useTLS != tc.tls {
+			t.Errorf("address(%q) = %q, %v, %v", tc.broker, addr, useTLS, err)
+		}
+	}
+}
+
+func TestMQTTConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	connect := make(chan []byte, 1)
+	go func() {
+		for code := byte(0); ; code = 5 {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, body, _ := readPacket(bufio.NewReader(conn))
+			connect <- body
+			conn.Write([]byte{0x20, 0x02, 0x00, code})
+			conn.Close()
+		}
+	}()
+
+	c := &mqttClient{cfg: &MQTTConfig{Broker: "tcp://" + ln.Addr().String(), ClientID: "id", Username: "u", Password: "p", KeepAlive: 30}}
+	conn, err := c.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	want := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2, 0, 30, 0, 2, 'i', 'd', 0, 1, 'u', 0, 1, 'p'}
+	if got := <-connect; !bytes.Equal(got, want) {
+		t.Errorf("connect = % x, want % x", got, want)
+	}
+
+	//拒绝连接
+	if _, err := c.connect(); err == nil {
+		t.Error("want connection refused")
+	}
+}
+
+func testMQTTClient(queue int, l *log.Logger) *mqttClient {
+	return &mqttClient{
+		cfg:      &MQTTConfig{},
+		logger:   l,
+		msgs:     make(chan *mqttMessage, queue),
+		retained: make(map[string][]byte),
+		pending:  make(map[string]bool),
+	}
+}
+
+//读取broker收到的PUBLISH: 主题和内容
+func readPublish(t *testing.T, conn net.Conn, r *bufio.Reader) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, body, err := readPacket(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body[2:])
+}
+
+//重连后先发布最新的保留消息，之前进入队列的消息不再发送，之后的状态变化按顺序发布
+func TestMQTTServe(t *testing.T) {
+	c := testMQTTClient(mqttQueue, log.New(ioutil.Discard, "", 0))
+	c.publish("s", []byte("down"), true)
+	c.publish("r", []byte("1"), false)
+
+	client, broker := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- c.serve(client) }()
+	r := bufio.NewReader(broker)
+	if got := readPublish(t, broker, r); got != "sdown" {
+		t.Fatalf("resent %q, want sdown", got)
+	}
+
+	c.publish("s", []byte("up"), true)
+	c.publish("s", []byte("down"), true)
+	c.publish("r", []byte("2"), false)
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, readPublish(t, broker, r))
+	}
+	want := []string{"sup", "sdown", "r2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("published %q, want %q", got, want)
+			break
+		}
+	}
+	//重连前的探测结果和重复的状态已经丢弃
+	broker.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, body, err := readPacket(r); err == nil {
+		t.Errorf("unexpected publish %q", body)
+	}
+	broker.Close()
+	if err := <-done; err == nil {
+		t.Error("serve: want error after close")
+	}
+}
+
+//队列满时记录丢弃的消息数，警告日志有间隔；丢弃的保留消息稍后重新发布
+func TestMQTTQueueFull(t *testing.T) {
+	var logs bytes.Buffer
+	c := testMQTTClient(1, log.New(&logs, "", 0))
+	c.publish("a", []byte("up"), true)
+	c.publish("b", []byte("down"), true)
+	for i := 0; i < 10; i++ {
+		c.publish("r", []byte("1"), false)
+	}
+	if n := strings.Count(logs.String(), "mqtt queue full"); n != 1 || !strings.Contains(logs.String(), "1 messages dropped") {
+		t.Errorf("logs %q", logs.String())
+	}
+	if c.dropped != 10 {
+		t.Errorf("dropped %d since last warning, want 10", c.dropped)
+	}
+	msgs := c.takeRetained(false)
+	if len(msgs) != 1 || msgs[0].topic != "b" || string(msgs[0].payload) != "down" || len(c.pending) != 0 {
+		t.Errorf("pending %v", msgs)
+	}
+}
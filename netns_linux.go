@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+//网络命名空间路径: 名称对应ip netns创建的/var/run/netns/<name>
+func netnsPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join("/var/run/netns", name)
+}
+
+//在网络命名空间中执行fn: 其中创建的socket属于该命名空间；name为空时直接执行
+func inNetns(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+	runtime.LockOSThread()
+
+	orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer orig.Close()
+	ns, err := os.Open(netnsPath(name))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer ns.Close()
+
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("netns %s: %s", name, err)
+	}
+	ferr := fn()
+	//恢复失败时不解锁线程，避免其他goroutine使用错误的命名空间
+	if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("restore netns: %s", err)
+	}
+	runtime.UnlockOSThread()
+	return ferr
+}
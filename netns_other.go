@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func inNetns(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+	return errors.New("network namespace is only supported on linux")
+}
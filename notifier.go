@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//默认的通知渠道: 使用全局邮件配置
+const defaultNotifier = "email"
+
+//通知渠道: 每个渠道收到同一批状态变化
+type Notifier interface {
+	Notify(ev *Event) error
+}
+
+//保存报警状态的渠道: 被维护、静默或者报警规则抑制的恢复也发送给这些渠道，用于结束报警
+type statefulNotifier interface {
+	Notifier
+	stateful()
+}
+
+//通知渠道配置: 在全局配置中定义，组通过名称引用
+type NotifierConfig struct {
+	Name string `json:"name"`
+	//渠道类型: email, webhook, dingtalk, wecom, feishu, slack, telegram, sms, pagerduty, alertmanager, exec, snmp
+	Type string `json:"type"`
+	//email: 接收人，为空时使用全局和组的接收人
+	To string `json:"to,omitempty"`
+	//webhook和机器人: 地址、额外的请求头和签名密钥
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Secret  string            `json:"secret,omitempty"`
+	//slack: 频道和用户名，为空时使用webhook的设置
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+	//telegram: bot token、默认会话和区域对应的会话，url为API地址，默认https://api.telegram.org
+	Token   string            `json:"token,omitempty"`
+	ChatID  string            `json:"chat_id,omitempty"`
+	ChatIDs map[string]string `json:"chat_ids,omitempty"`
+	//sms: 请求方法和模板(url、headers和body中使用{{.Phone}}和{{.Message}})，
+	//默认号码、联系人名称对应的号码和短信最大长度
+	Method    string            `json:"method,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Phones    []string          `json:"phones,omitempty"`
+	Contacts  map[string]string `json:"contacts,omitempty"`
+	MaxLength int               `json:"max_length,omitempty"`
+	//pagerduty: 集成的routing key，url默认为Events API v2地址
+	RoutingKey string `json:"routing_key,omitempty"`
+	//alertmanager: url为/api/v2/alerts的完整地址，报警中的generatorURL
+	GeneratorURL string `json:"generator_url,omitempty"`
+	//exec: 本地可执行文件和参数
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	//snmp: trap接收地址、版本和认证
+	SNMP *SNMPConfig `json:"snmp,omitempty"`
+	//HTTP请求或者脚本执行超时，默认10s；失败后的重试次数
+	Timeout string `json:"timeout,omitempty"`
+	Retries int    `json:"retries,omitempty"`
+}
+
+//检查渠道配置
+func (c *NotifierConfig) check() error {
+	if c.Name == "" {
+		return fmt.Errorf("notifier name is empty")
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("notifier %s: timeout %s", c.Name, err)
+		}
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("notifier %s: retries %d", c.Name, c.Retries)
+	}
+	switch c.Type {
+	case "email":
+	case "webhook", "dingtalk", "wecom", "feishu", "slack", "alertmanager":
+		return checkURL(c.Name, c.URL)
+	case "pagerduty":
+		if c.RoutingKey == "" {
+			return fmt.Errorf("notifier %s: routing_key is empty", c.Name)
+		}
+		if c.URL != "" {
+			return checkURL(c.Name, c.URL)
+		}
+	case "sms":
+		return c.checkSMS()
+	case "snmp":
+		if c.SNMP == nil {
+			return fmt.Errorf("notifier %s: snmp is empty", c.Name)
+		}
+		if err := c.SNMP.check(); err != nil {
+			return fmt.Errorf("notifier %s: %s", c.Name, err)
+		}
+	case "exec":
+		if c.Command == "" {
+			return fmt.Errorf("notifier %s: command is empty", c.Name)
+		}
+	case "telegram":
+		if c.Token == "" {
+			return fmt.Errorf("notifier %s: token is empty", c.Name)
+		}
+		if c.URL != "" {
+			return checkURL(c.Name, c.URL)
+		}
+	default:
+		return fmt.Errorf("notifier %s: unknown type %q", c.Name, c.Type)
+	}
+	return nil
+}
+
+//HTTP请求超时
+func (c *NotifierConfig) timeout() time.Duration {
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil || d <= 0 {
+		return defaultNotifyTimeout
+	}
+	return d
+}
+
+//返回隐藏密码的配置: 请求头、地址的路径和参数以及短信模板中可能包括密钥
+func (c *NotifierConfig) hideSecret() *NotifierConfig {
+	hc := *c
+	hc.URL = hideURL(c.URL)
+	if len(c.Headers) > 0 {
+		hc.Headers = make(map[string]string)
+		for k := range c.Headers {
+			hc.Headers[k] = "******"
+		}
+	}
+	if hc.Body != "" {
+		hc.Body = "******"
+	}
+	if hc.Secret != "" {
+		hc.Secret = "******"
+	}
+	if hc.Token != "" {
+		hc.Token = "******"
+	}
+	if hc.RoutingKey != "" {
+		hc.RoutingKey = "******"
+	}
+	if hc.SNMP != nil {
+		hc.SNMP = hc.SNMP.hideSecret()
+	}
+	return &hc
+}
+
+//隐藏地址中的用户、路径和参数，只保留协议和主机
+func hideURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "******"
+	}
+	hidden := u.Scheme + "://" + u.Host
+	if u.Path != "" && u.Path != "/" {
+		hidden += "/******"
+	}
+	if u.RawQuery != "" {
+		hidden += "?******"
+	}
+	return hidden
+}
+
+//创建通知渠道
+func newNotifier(c *NotifierConfig, cfg *Config, l *log.Logger) (Notifier, error) {
+	switch c.Type {
+	case "email":
+		return &emailNotifier{mail: cfg.Mail, to: c.To}, nil
+	case "webhook":
+		return &webhookNotifier{c}, nil
+	case "dingtalk":
+		return &dingtalkNotifier{c}, nil
+	case "wecom":
+		return &wecomNotifier{c}, nil
+	case "feishu":
+		return &feishuNotifier{c}, nil
+	case "slack":
+		return &slackNotifier{c}, nil
+	case "telegram":
+		return &telegramNotifier{c}, nil
+	case "sms":
+		return &smsNotifier{c, cfg.Groups}, nil
+	case "pagerduty":
+		return &pagerdutyNotifier{c}, nil
+	case "alertmanager":
+		return newAlertmanagerNotifier(c), nil
+	case "exec":
+		return &execNotifier{c, l}, nil
+	case "snmp":
+		return newSNMPNotifier(c.SNMP, cfg.SNMPDir)
+	}
+	return nil, fmt.Errorf("notifier %s: unknown type %q", c.Name, c.Type)
+}
+
+//检查渠道名称是否重复，以及组引用的渠道是否存在
+func checkNotifiers(list []*NotifierConfig, services []string, groups map[string]*Group) error {
+	//可以定义名称为email的渠道代替默认的渠道，但是只能定义一次
+	names := map[string]bool{defaultNotifier: true}
+	defined := make(map[string]bool)
+	for _, c := range list {
+		if err := c.check(); err != nil {
+			return err
+		}
+		if defined[c.Name] {
+			return fmt.Errorf("notifier %s is duplicate", c.Name)
+		}
+		names[c.Name], defined[c.Name] = true, true
+	}
+	for _, name := range services {
+		if !names[name] {
+			return fmt.Errorf("service notifier %s not found", name)
+		}
+	}
+	for area, g := range groups {
+		for _, name := range g.Notifiers {
+			if !names[name] {
+				return fmt.Errorf("group %s: notifier %s not found", area, name)
+			}
+		}
+	}
+	return nil
+}
+
+//命名的通知渠道
+type channel struct {
+	name string
+	Notifier
+}
+
+//发送到区域的全部通知渠道: all为包括被抑制的恢复的通知，发送给保存报警状态的渠道，为nil时和ev相同
+func (m *monitor) dispatch(area string, ev, all *Event) {
+	if all == nil {
+		all = ev
+	}
+	for _, c := range m.channels[area] {
+		e := ev
+		if _, ok := c.Notifier.(statefulNotifier); ok {
+			e = all
+		}
+		if e == nil {
+			continue
+		}
+		go func(c *channel, ev *Event) {
+			if err := c.Notify(ev); err != nil {
+				m.logger.Printf("[ERROR] send notify %s of %s %s\n", c.name, area, err)
+			} else {
+				m.logger.Printf("[INFO] send notify %s of %s ok\n", c.name, area)
+			}
+		}(c, e)
+	}
+}
+
+//创建各区域的通知渠道: 组没有指定时使用email
+func newChannels(cfg *Config, l *log.Logger) map[string][]*channel {
+	notifiers := make(map[string]Notifier)
+	notifiers[defaultNotifier] = &emailNotifier{mail: cfg.Mail}
+	for _, c := range cfg.Notifiers {
+		n, err := newNotifier(c, cfg, l)
+		if err != nil {
+			log.Fatalf("config notifiers %s\n", err)
+		}
+		notifiers[c.Name] = n
+	}
+
+	lookup := func(names []string) []*channel {
+		if len(names) == 0 {
+			names = []string{defaultNotifier}
+		}
+		var list []*channel
+		for _, name := range names {
+			if n, ok := notifiers[name]; ok {
+				list = append(list, &channel{name, n})
+			}
+		}
+		return list
+	}
+	channels := make(map[string][]*channel)
+	for area, g := range cfg.Groups {
+		channels[area] = lookup(g.Notifiers)
+	}
+	channels[serviceArea] = lookup(cfg.ServiceNotifiers)
+	return channels
+}
+
+//主机的状态变化: 在监控循环中创建的快照，通知渠道只读取快照
+type Transition struct {
+	//area/name
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Area    string `json:"area"`
+	Group   string `json:"group"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	//单独发送的通知类型: pmtu或者trace，主机状态没有变化；group为组状态变化，Key为area
+	Kind string `json:"kind,omitempty"`
+	RTT  string `json:"rtt,omitempty"`
+	//最后收到回复、最近离线和上线的时间
+	Last      time.Time `json:"last"`
+	DownSince time.Time `json:"down_since"`
+	UpSince   time.Time `json:"up_since"`
+	Severity  string    `json:"severity,omitempty"`
+	//离线提醒的次数和离线通知的时间
+	Remind  int       `json:"remind,omitempty"`
+	AlertAt time.Time `json:"-"`
+	//因为本设备离线而不可达的下级设备: name(address)
+	Unreachable []string `json:"unreachable,omitempty"`
+	//离线时的路由跟踪
+	Trace *traceroute `json:"trace,omitempty"`
+	//路径MTU和期望值
+	PMTU       int `json:"pmtu,omitempty"`
+	PMTUExpect int `json:"pmtu_expect,omitempty"`
+	//组状态变化时的组状态
+	Health *groupHealth `json:"health,omitempty"`
+	//报警规则和升级策略指定的接收人
+	Rcpt      []string `json:"-"`
+	Escalated []string `json:"-"`
+	//被抑制的恢复: 只发送给保存报警状态的渠道
+	Suppressed bool `json:"-"`
+}
+
+//主机的状态快照: prev为通知前的状态
+func newTransition(h *Host, prev string) *Transition {
+	t := &Transition{
+		Key:        hostKey(h.AreaID, h.Name),
+		Name:       h.Name,
+		Address:    h.Addr,
+		Area:       h.AreaID,
+		Group:      h.Area,
+		From:       prev,
+		To:         h.State,
+		RTT:        h.RTT,
+		Last:       h.Last,
+		DownSince:  h.DownSince,
+		UpSince:    h.UpSince,
+		Severity:   h.Severity,
+		Remind:     h.remind,
+		AlertAt:    h.alertAt,
+		Trace:      h.Trace,
+		PMTU:       h.PMTU,
+		PMTUExpect: h.PMTUExpect,
+		Rcpt:       append([]string{}, h.rcpt...),
+		Escalated:  append([]string{}, h.to...),
+	}
+	for _, c := range h.unreachable() {
+		t.Unreachable = append(t.Unreachable, fmt.Sprintf("%s(%s)", c.Name, c.Addr))
+	}
+	return t
+}
+
+//是否为上线状态
+func (t *Transition) up() bool {
+	return t.To == stateUp
+}
+
+//是否为正常状态: 主机上线、路径MTU恢复或者组全部在线
+func (t *Transition) ok() bool {
+	switch t.Kind {
+	case noticePMTU:
+		return t.PMTU >= t.PMTUExpect
+	case noticeGroup:
+		return t.To == groupUp
+	}
+	return t.up()
+}
+
+//一个区域在relay_time内的状态变化
+type Event struct {
+	Area     string    `json:"area"`
+	AreaName string    `json:"area_name"`
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity,omitempty"`
+	Subject  string    `json:"subject"`
+	//全部状态变化，同一主机可能有多次
+	Transitions []*Transition `json:"transitions"`
+	//合并的站点故障
+	Outages []*outage `json:"outages,omitempty"`
+}
+
+//创建通知: 合并站点故障，计算报警级别和标题
+func (m *monitor) newEvent(area string, ts []*Transition) *Event {
+	_, outages := m.correlate(area, ts)
+	t1 := ts[0]
+	if len(outages) > 0 {
+		t1 = outages[0].Down[0]
+	}
+	ev := &Event{
+		Area:        t1.Area,
+		AreaName:    t1.Group,
+		Time:        time.Now(),
+		Transitions: ts,
+		Outages:     outages,
+	}
+	notices := true
+	for _, t := range ts {
+		if severityLevel(t.Severity) > severityLevel(ev.Severity) {
+			ev.Severity = t.Severity
+		}
+		notices = notices && t.Kind != ""
+	}
+
+	subject := "网络设备状态变化通知"
+	switch {
+	case len(outages) > 0:
+		subject = "站点故障通知"
+	case notices:
+		//只有单独的通知时使用第一个通知的主题
+		subject = noticeSubjects[t1.Kind]
+	case t1.Remind > 0:
+		subject = "网络设备离线提醒"
+	}
+	if ev.Severity != "" {
+		subject = fmt.Sprintf("[%s] %s", strings.ToUpper(ev.Severity), subject)
+	}
+	ev.Subject = fmt.Sprintf("[%s] %s - %s", t1.Area, t1.Group, subject)
+	return ev
+}
+
+//不属于站点故障的状态变化
+func (ev *Event) rest() []*Transition {
+	covered := make(map[*Transition]bool)
+	for _, o := range ev.Outages {
+		for _, t := range o.Down {
+			covered[t] = true
+		}
+	}
+	var ts []*Transition
+	for _, t := range ev.Transitions {
+		if !covered[t] {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+//全部状态变化都是正常状态
+func (ev *Event) ok() bool {
+	for _, t := range ev.Transitions {
+		if !t.ok() {
+			return false
+		}
+	}
+	return true
+}
+
+//邮件通知
+type emailNotifier struct {
+	mail Mailer
+	to   string
+}
+
+func (n *emailNotifier) Notify(ev *Event) error {
+	return SendMail(n.mail, ev, n.to)
+}
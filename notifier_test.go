@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+//记录收到的通知
+type fakeNotifier struct {
+	events chan *Event
+}
+
+func (n *fakeNotifier) Notify(ev *Event) error {
+	n.events <- ev
+	return nil
+}
+
+//等待渠道收到通知
+func (n *fakeNotifier) wait(t *testing.T) *Event {
+	t.Helper()
+	select {
+	case ev := <-n.events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return nil
+}
+
+func TestCheckNotifiers(t *testing.T) {
+	groups := map[string]*Group{"a1": {Area: "a1", Notifiers: []string{"email", "noc-mail"}}}
+	for _, tc := range []struct {
+		list     []*NotifierConfig
+		services []string
+		ok       bool
+	}{
+		{[]*NotifierConfig{{Name: "noc-mail", Type: "email", To: "noc@example.com"}}, []string{"noc-mail"}, true},
+		{nil, nil, false},
+		{[]*NotifierConfig{{Name: "noc-mail", Type: "fax"}}, nil, false},
+		{[]*NotifierConfig{{Type: "email"}}, nil, false},
+		{[]*NotifierConfig{{Name: "noc-mail", Type: "email"}}, []string{"sms"}, false},
+		{[]*NotifierConfig{{Name: "noc-mail", Type: "email"}, {Name: "noc-mail", Type: "email"}}, nil, false},
+		//代替默认的email渠道
+		{[]*NotifierConfig{{Name: "email", Type: "email"}, {Name: "noc-mail", Type: "email"}}, nil, true},
+		{[]*NotifierConfig{{Name: "email", Type: "email"}, {Name: "email", Type: "email"}, {Name: "noc-mail", Type: "email"}}, nil, false},
+	} {
+		if err := checkNotifiers(tc.list, tc.services, groups); (err == nil) != tc.ok {
+			t.Errorf("%v %v: err = %v", tc.list, tc.services, err)
+		}
+	}
+}
+
+func TestNewChannels(t *testing.T) {
+	cfg := &Config{
+		Notifiers: []*NotifierConfig{{Name: "noc-mail", Type: "email", To: "noc@example.com"}},
+		Groups: map[string]*Group{
+			"a1": {Area: "a1"},
+			"a2": {Area: "a2", Notifiers: []string{"email", "noc-mail"}},
+		},
+		ServiceNotifiers: []string{"noc-mail"},
+	}
+	channels := newChannels(cfg, log.New(ioutil.Discard, "", 0))
+	names := func(area string) []string {
+		var list []string
+		for _, c := range channels[area] {
+			list = append(list, c.name)
+		}
+		return list
+	}
+	if got := names("a1"); len(got) != 1 || got[0] != defaultNotifier {
+		t.Errorf("a1 channels %v", got)
+	}
+	if got := names("a2"); len(got) != 2 || got[1] != "noc-mail" {
+		t.Errorf("a2 channels %v", got)
+	}
+	if got := names(serviceArea); len(got) != 1 || got[0] != "noc-mail" {
+		t.Errorf("service channels %v", got)
+	}
+	if n, ok := channels["a2"][1].Notifier.(*emailNotifier); !ok || n.to != "noc@example.com" {
+		t.Errorf("noc-mail notifier %#v", channels["a2"][1].Notifier)
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	m := &monitor{cfg: &Config{}}
+	h := &Host{Name: "web1", Addr: "10.0.0.1", AreaID: "a1", Area: "site1", State: stateDown, Severity: "warning"}
+	down := newTransition(h, stateUp)
+	//快照不受之后主机状态变化的影响
+	h.State, h.Stat, h.Severity = stateUp, true, "critical"
+	recovered := newTransition(h, stateDown)
+	ev := m.newEvent("a1", []*Transition{down, recovered})
+	if ev.Area != "a1" || ev.AreaName != "site1" || ev.Severity != "critical" {
+		t.Errorf("event %+v", ev)
+	}
+	if ev.Subject != "[a1] site1 - [CRITICAL] 网络设备状态变化通知" {
+		t.Errorf("subject %q", ev.Subject)
+	}
+	if down.up() || !recovered.up() || down.Severity != "warning" {
+		t.Errorf("snapshots %+v %+v", down, recovered)
+	}
+
+	//离线提醒
+	h.State, h.Stat, h.Severity, h.remind = stateDown, false, "", 2
+	if ev = m.newEvent("a1", []*Transition{newTransition(h, stateDown)}); ev.Subject != "[a1] site1 - 网络设备离线提醒" {
+		t.Errorf("remind subject %q", ev.Subject)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+//默认触发站点故障的最少离线主机数
+const defaultOutageMinHosts = 3
+
+//同一组或者同一网段的多台主机同时离线
+type outage struct {
+	//组名称或者网段
+	Name  string        `json:"name"`
+	Down  []*Transition `json:"down"`
+	Total int           `json:"total"`
+}
+
+func (o *outage) String() string {
+	return fmt.Sprintf("site outage: %s %d of %d hosts down", o.Name, len(o.Down), o.Total)
+}
+
+//主机所在网段: IPv4为/24，IPv6为/64，不是IP地址时返回空
+func subnet(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+//在一个区域RelayTime窗口内的状态变化中查找站点故障:
+//组或者网段中离线主机的比例达到outage_ratio时合并为一个通知，返回其余的状态变化；
+//通知按区域发送，网段的主机总数也只统计本区域的主机
+func (m *monitor) correlate(area string, ts []*Transition) ([]*Transition, []*outage) {
+	ratio := m.cfg.OutageRatio
+	if ratio <= 0 {
+		return ts, nil
+	}
+	min := m.cfg.OutageMinHosts
+	if min <= 0 {
+		min = defaultOutageMinHosts
+	}
+
+	//组和网段的主机总数
+	var total int
+	subnets := make(map[string]int)
+	for _, h := range m.cfg.Hosts {
+		if h.AreaID != area {
+			continue
+		}
+		total++
+		if s := subnet(h.Addr); s != "" {
+			subnets[s]++
+		}
+	}
+
+	//同一主机在窗口内多次变化时只计算最后一次，单独的通知不计算
+	last := make(map[string]*Transition)
+	for _, t := range ts {
+		if t.Kind == "" {
+			last[t.Key] = t
+		}
+	}
+	var down []*Transition
+	for _, t := range ts {
+		if !t.up() && last[t.Key] == t {
+			down = append(down, t)
+		}
+	}
+
+	var outages []*outage
+	covered := make(map[*Transition]bool)
+	add := func(name string, total int, d []*Transition) {
+		if len(d) < min || len(d)*100 < total*ratio {
+			return
+		}
+		outages = append(outages, &outage{Name: name, Down: d, Total: total})
+		for _, t := range d {
+			covered[t] = true
+		}
+	}
+
+	//先按组合并，再按网段合并剩余主机
+	if len(down) > 0 {
+		add(fmt.Sprintf("[%s] %s", area, down[0].Group), total, down)
+	}
+	bySubnet := make(map[string][]*Transition)
+	for _, t := range down {
+		if s := subnet(t.Address); s != "" && !covered[t] {
+			bySubnet[s] = append(bySubnet[s], t)
+		}
+	}
+	for s, d := range bySubnet {
+		add(s, subnets[s], d)
+	}
+	sort.Slice(outages, func(i, j int) bool { return outages[i].Name < outages[j].Name })
+
+	var rest []*Transition
+	for _, t := range ts {
+		if !covered[t] {
+			rest = append(rest, t)
+		}
+	}
+	return rest, outages
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSubnet(t *testing.T) {
+	for addr, want := range map[string]string{
+		"10.1.2.3":         "10.1.2.0/24",
+		"2001:db8:1:2::10": "2001:db8:1:2::/64",
+		"router.example":   "",
+	} {
+		if got := subnet(addr); got != want {
+			t.Errorf("subnet(%s) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+//创建组a中的n台主机: 10.0.<net>.x
+func outageHosts(area string, net, n int) []*Host {
+	var hs []*Host
+	for i := 1; i <= n; i++ {
+		hs = append(hs, &Host{
+			Name:   fmt.Sprintf("%s-%d", area, i),
+			Addr:   fmt.Sprintf("10.0.%d.%d", net, i),
+			AreaID: area,
+			Area:   "site " + area,
+		})
+	}
+	return hs
+}
+
+//主机的状态变化快照
+func transitions(hs ...*Host) []*Transition {
+	var ts []*Transition
+	for _, h := range hs {
+		ts = append(ts, newTransition(h, ""))
+	}
+	return ts
+}
+
+func TestCorrelate(t *testing.T) {
+	a := outageHosts("a", 1, 4)
+	m := &monitor{cfg: &Config{Hosts: a, OutageRatio: 50}}
+
+	//3/4离线，达到比例和最少主机数
+	up := &Host{Name: "x", AreaID: "a", Stat: true, State: stateUp}
+	ts := transitions(up, a[0], a[1], a[2])
+	rest, outages := m.correlate("a", ts)
+	if len(outages) != 1 || outages[0].Name != "[a] site a" || len(outages[0].Down) != 3 || outages[0].Total != 4 {
+		t.Fatalf("outages %v", outages)
+	}
+	if len(rest) != 1 || rest[0] != ts[0] {
+		t.Errorf("rest %v, want only the recovered host", rest)
+	}
+
+	//少于最少主机数
+	if rest, outages = m.correlate("a", transitions(a[:2]...)); len(outages) != 0 || len(rest) != 2 {
+		t.Errorf("2 hosts: outages %v rest %d", outages, len(rest))
+	}
+
+	//路由跟踪结果不是新的离线
+	ts = transitions(a[:3]...)
+	ts[2].Kind = noticeTrace
+	if _, outages = m.correlate("a", ts); len(outages) != 0 {
+		t.Errorf("trace notice counted as down: %v", outages)
+	}
+
+	//同一主机在窗口内离线后又恢复时只计算最后一次
+	a[2].State = stateUp
+	ts = append(transitions(a[:3]...), transitions(a[2])...)
+	if _, outages = m.correlate("a", ts); len(outages) != 0 {
+		t.Errorf("recovered host counted as down: %v", outages)
+	}
+	a[2].State = ""
+
+	//没有设置比例时不合并
+	m.cfg.OutageRatio = 0
+	if rest, outages = m.correlate("a", transitions(a[:3]...)); outages != nil || len(rest) != 3 {
+		t.Errorf("disabled: outages %v rest %d", outages, len(rest))
+	}
+}
+
+func TestCorrelateSubnet(t *testing.T) {
+	//组内离线比例不够，网段内比例足够
+	hs := append(outageHosts("a", 1, 4), outageHosts("a", 2, 6)...)
+	m := &monitor{cfg: &Config{Hosts: hs, OutageRatio: 50}}
+	rest, outages := m.correlate("a", transitions(hs[:3]...))
+	if len(outages) != 1 || outages[0].Name != "10.0.1.0/24" || outages[0].Total != 4 {
+		t.Fatalf("outages %v", outages)
+	}
+	if len(rest) != 0 {
+		t.Errorf("rest %v", rest)
+	}
+}
+
+func TestCorrelateScope(t *testing.T) {
+	//同一网段的主机分在两个区域: 网段总数只统计本区域的主机
+	a, b := outageHosts("a", 1, 3), outageHosts("b", 1, 10)
+	for i, h := range b {
+		h.Addr = fmt.Sprintf("10.0.1.%d", 100+i)
+	}
+	m := &monitor{cfg: &Config{Hosts: append(a, b...), OutageRatio: 50, OutageMinHosts: 2}}
+	//另一个区域的主机在同一批中出现时不计入
+	_, outages := m.correlate("a", transitions(a[:2]...))
+	if len(outages) != 1 || len(outages[0].Down) != 2 || outages[0].Total != 3 {
+		t.Fatalf("outages %v", outages)
+	}
+
+	//组内比例不够时，网段也使用本区域的总数: 2/3达到比例
+	a = append(a, outageHosts("a", 2, 3)...)
+	m.cfg.Hosts = append(a, b...)
+	_, outages = m.correlate("a", transitions(a[:2]...))
+	if len(outages) != 1 || outages[0].Name != "10.0.1.0/24" || outages[0].Total != 3 {
+		t.Fatalf("subnet outages %v", outages)
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	fastping "github.com/tatsushid/go-fastping"
 	"log"
 	"net"
 	"net/http"
@@ -12,20 +12,63 @@ import (
 
 var localserver = "127.0.0.1"
 
-//fastping received ICMP message
+//received ICMP message
 type response struct {
 	addr *net.IPAddr
 	rtt  time.Duration
+	p    *probe
+}
+
+//使用相同探测参数的一组主机
+type probe struct {
+	opt  probeOption
+	ping *Pinger
+	//IP地址对应的主机
+	hosts   map[string]*Host
+	results map[string]*response
 }
 
 //监控程序主体
 type monitor struct {
-	ping *fastping.Pinger
+	probes []*probe
 	//channel发送邮件
-	mail    chan *Host
-	logger  *log.Logger
-	cfg     *Config
-	results map[string]*response
+	mail   chan *Transition
+	logger *log.Logger
+	cfg    *Config
+	//路径MTU探测结果、并发限制和正在探测的主机
+	pmtu     chan *pmtuResult
+	pmtuSem  chan bool
+	pmtuBusy map[*Host]bool
+	//路由跟踪结果、并发限制和正在跟踪的主机
+	trace    chan *traceResult
+	traceSem chan bool
+	tracing  map[*Host]bool
+	//拓扑推断的路径跟踪结果、并发限制和还没有完成的主机数
+	path        chan *pathResult
+	pathSem     chan bool
+	pathPending int
+	//组的汇总状态
+	groups map[string]*groupHealth
+	//业务服务状态
+	services []*serviceStatus
+	//已经完成一轮探测的probe
+	idle map[*probe]bool
+	//status查询使用的主机状态副本，由监控循环更新
+	hosts []*Host
+	//保护status查询与监控循环共用的数据
+	mu sync.RWMutex
+	//报警规则
+	rules *ruleSet
+	//维护窗口和文件修改时间
+	maintenance []*Maintenance
+	maintMtime  time.Time
+	//静默和确认请求
+	silences   []*Silence
+	silenceReq chan *silenceRequest
+	//各区域的通知渠道
+	channels map[string][]*channel
+	//MQTT状态发布，没有配置时为nil
+	mqtt *mqttClient
 }
 
 //根据config和log创建monitor
@@ -34,9 +77,6 @@ func NewMonitor(cfg *Config, l *log.Logger) *monitor {
 	m.cfg = cfg
 	m.logger = l
 
-	//创建fastping.Pinger
-	var p = fastping.NewPinger()
-
 	//使用time包解析间隔时间，interval格式15s
 	d, err := time.ParseDuration(cfg.Interval)
 	if err != nil {
@@ -46,7 +86,6 @@ func NewMonitor(cfg *Config, l *log.Logger) *monitor {
 		d = def_d
 	}
 	m.logger.Printf("Interval time: %+v\n", d)
-	p.MaxRTT = d
 
 	if cfg.Times <= int(mini_times) {
 		cfg.Times = mini_times
@@ -56,47 +95,83 @@ func NewMonitor(cfg *Config, l *log.Logger) *monitor {
 
 	hosts := cfg.Hosts
 
-	m.results = make(map[string]*response)
-
-	//添加需要监控的主机到fastping.Pinger
+	//添加需要监控的主机到对应探测参数的Pinger
 	for i := 0; i < len(hosts); i++ {
 		ra, err := net.ResolveIPAddr("ip", hosts[i].Addr)
 		if err != nil {
 			m.logger.Printf("ResolveIPAddr: %s %s\n", hosts[i].Name, err)
 			continue
 		}
-		m.logger.Printf("AddIPAddr: %s, [%s]\n", hosts[i].Name, ra)
-		m.results[ra.String()] = nil
-		p.AddIPAddr(ra)
+		opt := hosts[i].probeOption()
+		pr := m.probe(opt, d)
+		m.logger.Printf("AddIPAddr: %s, [%s] %s\n", hosts[i].Name, ra, opt)
+		pr.hosts[ra.String()] = hosts[i]
+		pr.results[ra.String()] = nil
+		pr.ping.AddIPAddr(ra)
 	}
 	m.logger.Println("-------------------------")
 
-	//ICMP Echo大小32byte
-	p.Size = 32
-	m.ping = p
-
-	m.mail = make(chan *Host, 2*len(hosts))
+	m.mail = make(chan *Transition, 2*len(hosts))
+	m.pmtu = make(chan *pmtuResult)
+	m.pmtuSem = make(chan bool, pmtuConcurrency)
+	m.pmtuBusy = make(map[*Host]bool)
+	m.trace = make(chan *traceResult)
+	m.traceSem = make(chan bool, traceConcurrency)
+	m.tracing = make(map[*Host]bool)
+	m.path = make(chan *pathResult)
+	m.pathSem = make(chan bool, pathConcurrency)
+	m.silenceReq = make(chan *silenceRequest)
+	m.channels = newChannels(cfg, l)
+	if cfg.MQTT != nil && cfg.MQTT.Broker != "" {
+		m.mqtt = newMQTTClient(cfg.MQTT, l)
+	}
+	m.groups = make(map[string]*groupHealth)
+	m.idle = make(map[*probe]bool)
+	m.services = newServices(cfg.Services, cfg.Groups)
+	m.rules = newRuleSet(cfg.RulesDir)
+	m.rules.reload(m)
+	m.updateMaintenance()
+	m.publish()
 
 	return m
 }
 
+//返回探测参数对应的probe，没有则创建
+func (m *monitor) probe(opt probeOption, d time.Duration) *probe {
+	for _, pr := range m.probes {
+		if pr.opt == opt {
+			return pr
+		}
+	}
+	p := NewPinger(opt)
+	p.MaxRTT = d
+	pr := &probe{
+		opt:     opt,
+		ping:    p,
+		hosts:   make(map[string]*Host),
+		results: make(map[string]*response),
+	}
+	m.probes = append(m.probes, pr)
+	return pr
+}
+
 //发送报警邮件
 func (m *monitor) resv() {
 	resv := m.cfg.MailResv
-	for h := range m.mail {
+	for t := range m.mail {
 		if m.cfg.Debug {
-			m.logger.Printf("[DEBUG] resv notice %s\n", h.Name)
+			m.logger.Printf("[DEBUG] resv notice %s\n", t.Name)
 		}
-		read, ok := resv[h.AreaID]
+		read, ok := resv[t.Area]
 		if ok {
 			select {
-			case read <- h:
+			case read <- t:
 				if m.cfg.Debug {
-					m.logger.Printf("[DEBUG] resv send to read %s\n", h.Name)
+					m.logger.Printf("[DEBUG] resv send to read %s\n", t.Name)
 				}
 			case <-time.After(2 * time.Second):
 				if m.cfg.Debug {
-					m.logger.Printf("[DEBUG] resv send timeout %s\n", h.Name)
+					m.logger.Printf("[DEBUG] resv send timeout %s\n", t.Name)
 				}
 				continue
 			}
@@ -120,77 +195,207 @@ func (m *monitor) debug(format string, v ...interface{}) {
 	}
 }
 
-func (m *monitor) status(ls string) {
-	var mux = http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//只允许本机访问: 由http服务反向代理
+func (m *monitor) local(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if tcp, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
 			if tcp.IP.Equal(net.ParseIP(localserver)) {
-				var lock sync.Mutex
-				lock.Lock()
-				var hosts = make([]*Host, 0)
-				var areaID = r.FormValue("q")
-				if areaID != "" {
-					for i := 0; i < len(m.cfg.Hosts); i++ {
-						if m.cfg.Hosts[i].AreaID == areaID {
-							hosts = append(hosts, m.cfg.Hosts[i])
-						}
-					}
-				}
-				var b []byte
-				if len(hosts) == 0 {
-					b, err = Json(m.cfg.Hosts)
-				} else {
-					b, err = Json(hosts)
-				}
-				lock.Unlock()
-				if err != nil {
-					m.logger.Println("json", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				callback := r.FormValue("callback")
-				var s string
-				if callback != "" {
-					s = fmt.Sprintf("%s(%s)", callback, b)
-				} else {
-					s = string(b)
-				}
-				w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
-				fmt.Fprintf(w, "%s", s)
+				h(w, r)
 			}
 		} else {
 			m.debug("error reject access: %s\n", r.RemoteAddr)
 			w.WriteHeader(http.StatusForbidden)
 			fmt.Fprintf(w, "no allowed: %s", r.RemoteAddr)
 		}
-	})
+	}
+}
+
+//输出JSON: 支持jquery的callback
+func (m *monitor) writeJson(w http.ResponseWriter, r *http.Request, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		m.logger.Println("json", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	callback := r.FormValue("callback")
+	var s string
+	if callback != "" {
+		s = fmt.Sprintf("%s(%s)", callback, b)
+	} else {
+		s = string(b)
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	fmt.Fprintf(w, "%s", s)
+}
+
+//复制主机状态供status查询: http请求不读取监控循环正在修改的主机
+func (m *monitor) publish() {
+	var hosts = make([]*Host, len(m.cfg.Hosts))
+	for i, h := range m.cfg.Hosts {
+		c := *h
+		hosts[i] = &c
+	}
+	m.mu.Lock()
+	m.hosts = hosts
+	m.mu.Unlock()
+}
+
+//按区域过滤主机状态的副本，areaID为空或者没有匹配时返回全部主机
+func (m *monitor) filter(areaID string) []*Host {
+	m.mu.RLock()
+	all := m.hosts
+	m.mu.RUnlock()
+	var hosts = make([]*Host, 0)
+	if areaID != "" {
+		for i := 0; i < len(all); i++ {
+			if all[i].AreaID == areaID {
+				hosts = append(hosts, all[i])
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return all
+	}
+	return hosts
+}
+
+//主机最近一次的路由跟踪
+type hostTrace struct {
+	Name  string      `json:"name"`
+	Addr  string      `json:"address"`
+	Area  string      `json:"area"`
+	Trace *traceroute `json:"trace"`
+}
+
+func (m *monitor) status(ls string) {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/", m.local(func(w http.ResponseWriter, r *http.Request) {
+		m.writeJson(w, r, m.filter(r.FormValue("q")))
+	}))
+	//组的汇总状态
+	mux.HandleFunc("/status/groups", m.local(func(w http.ResponseWriter, r *http.Request) {
+		m.writeJson(w, r, m.groupList())
+	}))
+	//业务服务状态和变化记录
+	mux.HandleFunc("/status/services", m.local(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		m.writeJson(w, r, m.services)
+	}))
+	//根据路径跟踪推断的拓扑
+	mux.HandleFunc("/status/topology", m.local(func(w http.ResponseWriter, r *http.Request) {
+		m.writeJson(w, r, m.topology())
+	}))
+	//路由跟踪: q为区域, addr为主机地址
+	mux.HandleFunc("/status/trace", m.local(func(w http.ResponseWriter, r *http.Request) {
+		var traces = make([]*hostTrace, 0)
+		addr := r.FormValue("addr")
+		for _, h := range m.filter(r.FormValue("q")) {
+			if h.Trace == nil || (addr != "" && h.Addr != addr) {
+				continue
+			}
+			traces = append(traces, &hostTrace{h.Name, h.Addr, h.Area, h.Trace})
+		}
+		m.writeJson(w, r, traces)
+	}))
+	//确认和静默: 由http服务认证后转发
+	mux.HandleFunc("/admin/ack", m.local(m.silenceHandler))
+	mux.HandleFunc("/admin/silence", m.local(m.silenceHandler))
 	log.Fatal(http.ListenAndServe(ls, mux))
 }
 
 //启动监控
 func (m *monitor) start() {
-	onRecv, onIdle := make(chan *response), make(chan bool)
-	//m.recv, m.idle = onRecv, onIdle
-	m.ping.OnRecv = func(ra *net.IPAddr, rtt time.Duration) {
-		onRecv <- &response{ra, rtt}
-	}
-	m.ping.OnIdle = func() {
-		onIdle <- true
+	onRecv, onIdle, onStop := make(chan *response), make(chan *probe), make(chan *probe)
+	for _, pr := range m.probes {
+		pr := pr
+		pr.ping.OnRecv = func(ra *net.IPAddr, rtt time.Duration) {
+			onRecv <- &response{ra, rtt, pr}
+		}
+		pr.ping.OnIdle = func() {
+			onIdle <- pr
+		}
+		pr.ping.RunLoop()
+		go func() {
+			<-pr.ping.Done()
+			onStop <- pr
+		}()
 	}
-	m.ping.RunLoop()
 
 	times := m.cfg.Times
 
+	//路径MTU探测间隔
+	pmtuInterval := 5 * time.Minute
+	if m.cfg.PMTUInterval != "" {
+		d, err := time.ParseDuration(m.cfg.PMTUInterval)
+		if err != nil {
+			log.Fatalf("config pmtu_interval %s\n", err)
+		}
+		pmtuInterval = d
+	}
+	pmtuTick := time.NewTicker(pmtuInterval)
+	defer pmtuTick.Stop()
+
+	//路径跟踪间隔，为0时不跟踪
+	topoInterval := time.Hour
+	if m.cfg.TopologyInterval != "" {
+		d, err := time.ParseDuration(m.cfg.TopologyInterval)
+		if err != nil {
+			log.Fatalf("config topology_interval %s\n", err)
+		}
+		topoInterval = d
+	}
+	var topoTick <-chan time.Time
+	if topoInterval > 0 {
+		t := time.NewTicker(topoInterval)
+		defer t.Stop()
+		topoTick = t.C
+	}
+
+	//离线提醒和升级
+	var repeat time.Duration
+	if m.cfg.RepeatInterval != "" {
+		repeat, _ = time.ParseDuration(m.cfg.RepeatInterval)
+	}
+	remindTick := time.NewTicker(time.Minute)
+	defer remindTick.Stop()
+
 	for {
 		select {
+		case <-remindTick.C:
+			m.remind(repeat)
+
+		case <-pmtuTick.C:
+			m.checkPMTU()
+
+		case r := <-m.pmtu:
+			m.updatePMTU(r)
+			m.publish()
+
+		case <-topoTick:
+			m.tracePaths()
+
+		case req := <-m.silenceReq:
+			req.reply <- m.silence(req)
+
+		case r := <-m.path:
+			m.updatePath(r)
+
+		case r := <-m.trace:
+			m.traced(r)
+			m.publish()
+
 		case rm := <-onRecv:
 			raddr := rm.addr.String()
-			if _, ok := m.results[raddr]; ok {
-				m.results[raddr] = rm
-				host := Get(m.cfg.Hosts, raddr)
+			if _, ok := rm.p.results[raddr]; ok {
+				rm.p.results[raddr] = rm
+				host := rm.p.hosts[raddr]
 
 				//更新主机ping延迟时间
 				host.RTT = rm.rtt.String()
+				host.addRTT(rm.rtt)
+				m.publishResult(host, rm.rtt)
 				last := host.Last
 				//更新主机最后ping正常时间
 				host.Last = time.Now()
@@ -206,29 +411,45 @@ func (m *monitor) start() {
 				//更新主机状态，如果times为零，并且主机状态为down
 				if host.Times == 0 && !host.Stat {
 					host.Stat = true
+					prev := host.State
+					host.State = stateUp
+					host.UpSince = host.Last
+					//恢复后清除确认
+					host.Ack = nil
 					//打印日志并发送邮件
 					m.logger.Printf("[INFO] %s\n", host)
+					m.publishState(host)
 
 					//跳过后续操作，如果主机上次更新时间为0
 					if last.IsZero() {
 						m.debug("[DEBUG] %s ok:, last time: %v, rtt %s\n",
 							host.Name, host.Last, host.RTT)
+					} else if prev == stateUnreachable {
+						//不可达时没有发送离线通知，恢复时也不发送
+						m.debug("[DEBUG] %s recovered from unreachable\n", host.Name)
 					} else {
-						m.mail <- host
+						m.notify(host, prev)
 					}
+					m.stopEscalation(host)
 				}
 			}
 
-		case <-onIdle:
+		case pr := <-onIdle:
+			//报警规则和维护窗口有变化时重新加载
+			m.rules.reload(m)
+			m.updateMaintenance()
+			m.expireSilences()
 			//测试监控服务器自身网络状态
 			if err := m.heartbeat(); err != nil {
 				m.logger.Printf("[ERROR] heartbeat to %s failed %s\n", m.cfg.Heartbeat, err)
 				continue
 			}
 
-			for raddr, rm := range m.results {
-				host := Get(m.cfg.Hosts, raddr)
+			for raddr, rm := range pr.results {
+				host := pr.hosts[raddr]
 				if rm == nil {
+					host.addRTT(-1)
+					m.publishResult(host, -1)
 					//计数最大为15
 					if host.Times < 15 {
 						host.Times += 1
@@ -239,13 +460,87 @@ func (m *monitor) start() {
 					//更新主机状态，如果times大于config中指定的times，并且主机状态为up
 					if host.Times >= times && host.Stat {
 						host.Stat = false
-						//打印日志，发送邮件
+						//离线时间从最后一次收到回复开始
+						host.DownSince = host.Last
+						if host.DownSince.IsZero() {
+							host.DownSince = time.Now()
+						}
+						//上级设备离线时只标记为不可达，由上级设备发送通知
+						if p := host.failedParent(); p != nil {
+							host.State = stateUnreachable
+							m.logger.Printf("[WARN] %s, parent %s failed\n", host, p.Name)
+							m.publishState(host)
+							continue
+						}
+						host.State = stateDown
+						//打印日志，发送邮件后进行路由跟踪
 						m.logger.Printf("[EORROR] %s, failed times %d\n", host, host.Times)
-						m.mail <- host
+						m.publishState(host)
+						m.startTrace(pr.opt, raddr, host, m.notify(host, stateUp))
 					}
 				}
-				m.results[raddr] = nil
+				pr.results[raddr] = nil
+			}
+			m.idle[pr] = true
+
+			//上级设备已经恢复，但是主机仍然离线
+			for raddr, host := range pr.hosts {
+				if host.State == stateUnreachable && host.failedParent() == nil {
+					host.State = stateDown
+					m.logger.Printf("[EORROR] %s, parent recovered\n", host)
+					m.publishState(host)
+					m.startTrace(pr.opt, raddr, host, m.notify(host, stateUnreachable))
+				}
+			}
+			m.updateGroups()
+			m.updateServices()
+			//每个探测周期更新一次status查询的主机状态
+			m.publish()
+
+		case pr := <-onStop:
+			m.stopped(pr)
+			m.updateGroups()
+			m.updateServices()
+			m.publish()
+		}
+	}
+}
+
+//发送主机的快照作为单独的通知，不影响状态变化的通知
+func (m *monitor) sendNotice(h *Host, kind string) {
+	if m.suppressed(h) {
+		return
+	}
+	t := newTransition(h, h.State)
+	t.Kind = kind
+	//路径MTU变化不经过报警规则，不使用状态变化时规则设置的级别和接收人
+	if kind == noticePMTU {
+		t.Severity, t.Rcpt, t.Escalated = "", nil, nil
+	}
+	m.mail <- t
+}
+
+//Pinger出错退出后不再探测其中的主机，将这些主机标记为离线并发送通知；
+//业务服务计算时按已经完成探测处理，不再等待这个probe
+func (m *monitor) stopped(pr *probe) {
+	m.logger.Printf("[ERROR] ping %s stopped: %s\n", pr.opt, pr.ping.Err())
+	m.idle[pr] = true
+	for raddr, host := range pr.hosts {
+		pr.results[raddr] = nil
+		//已经离线并通知过的主机不再重复发送
+		notice := host.Stat || host.Last.IsZero()
+		prev := host.State
+		host.Times = m.cfg.Times
+		host.Stat = false
+		host.State = stateDown
+		if notice {
+			host.DownSince = host.Last
+			if host.DownSince.IsZero() {
+				host.DownSince = time.Now()
 			}
+			m.logger.Printf("[EORROR] %s, probe stopped\n", host)
+			m.publishState(host)
+			m.notify(host, prev)
 		}
 	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestProbeStopped(t *testing.T) {
+	up := &Host{Name: "up", Stat: true, Last: time.Now()}
+	down := &Host{Name: "down", Times: 5, Last: time.Now().Add(-time.Hour)}
+	never := &Host{Name: "never"}
+	p := NewPinger(probeOption{})
+	p.err = errors.New("socket closed")
+	pr := &probe{
+		ping:    p,
+		hosts:   map[string]*Host{"10.0.0.1": up, "10.0.0.2": down, "10.0.0.3": never},
+		results: map[string]*response{"10.0.0.1": {}, "10.0.0.2": nil, "10.0.0.3": nil},
+	}
+	m := &monitor{
+		mail:   make(chan *Transition, 3),
+		logger: log.New(ioutil.Discard, "", 0),
+		cfg:    &Config{Times: 5},
+		idle:   make(map[*probe]bool),
+		rules:  newRuleSet(""),
+	}
+	m.stopped(pr)
+	close(m.mail)
+
+	sent := make(map[string]bool)
+	for h := range m.mail {
+		sent[h.Name] = true
+	}
+	//已经离线并通知过的主机不再重复通知
+	if !sent["up"] || !sent["never"] || sent["down"] {
+		t.Errorf("notified %v, want up and never", sent)
+	}
+	for _, h := range pr.hosts {
+		if h.Stat || h.Times != 5 {
+			t.Errorf("%s: status %v times %d, want down", h.Name, h.Stat, h.Times)
+		}
+	}
+	if pr.results["10.0.0.1"] != nil {
+		t.Error("result not cleared")
+	}
+	//停止的probe不再阻塞业务服务的计算
+	if !m.idle[pr] {
+		t.Error("stopped probe not counted as idle")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := &Host{Name: "r1", AreaID: "a", RTT: "1ms"}
+	m := &monitor{cfg: &Config{Hosts: []*Host{h, {Name: "r2", AreaID: "b"}}}}
+	m.publish()
+	h.RTT = "2ms"
+	hosts := m.filter("a")
+	//status查询读取的是副本
+	if len(hosts) != 1 || hosts[0] == h || hosts[0].RTT != "1ms" {
+		t.Errorf("filter(a) = %v", hosts)
+	}
+	if hosts = m.filter("c"); len(hosts) != 2 {
+		t.Errorf("filter(c) returned %d hosts, want all", len(hosts))
+	}
+	m.publish()
+	if hosts = m.filter("a"); hosts[0].RTT != "2ms" {
+		t.Errorf("rtt %s after publish", hosts[0].RTT)
+	}
+}
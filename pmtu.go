@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	//PMTU探测的上限: 支持巨型帧
+	maxPMTU = 9000
+	//PMTU探测的并发数
+	pmtuConcurrency = 8
+	pmtuTimeout     = 2 * time.Second
+)
+
+//PMTU探测结果
+type pmtuResult struct {
+	host *Host
+	mtu  int
+	err  error
+}
+
+//IP头部和ICMP头部长度
+func ipOverhead(ip net.IP) int {
+	if isIPv4(ip) {
+		return 20 + icmpHeaderLen
+	}
+	return 40 + icmpHeaderLen
+}
+
+//发送指定MTU大小且不允许分片的Echo: 失败时重试一次，避免丢包造成误判
+func pmtuProbe(opt probeOption, ip *net.IPAddr, mtu int) bool {
+	opt.Size = mtu - ipOverhead(ip.IP)
+	for i := 0; i < 2; i++ {
+		if _, err := echoOnce(opt, ip, true, pmtuTimeout); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+//二分查找到主机的路径MTU
+func discoverPMTU(opt probeOption, ip *net.IPAddr) (int, error) {
+	//IPv4和IPv6的最小MTU
+	lo := 1280
+	if isIPv4(ip.IP) {
+		lo = 68
+	}
+	if !pmtuProbe(opt, ip, lo) {
+		return 0, errors.New("no reply with minimum mtu")
+	}
+	hi := maxPMTU
+	if pmtuProbe(opt, ip, hi) {
+		return hi, nil
+	}
+	//lo成功, hi失败
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if pmtuProbe(opt, ip, mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+//对配置了pmtu的在线主机进行路径MTU探测，结果发送到m.pmtu；
+//上一次探测还没有完成的主机跳过
+func (m *monitor) checkPMTU() {
+	for _, pr := range m.probes {
+		for raddr, host := range pr.hosts {
+			if host.PMTUExpect == 0 || !host.Stat || m.pmtuBusy[host] {
+				continue
+			}
+			ip, err := net.ResolveIPAddr("ip", raddr)
+			if err != nil {
+				continue
+			}
+			m.pmtuBusy[host] = true
+			go func(opt probeOption, host *Host, ip *net.IPAddr) {
+				m.pmtuSem <- true
+				mtu, err := discoverPMTU(opt, ip)
+				<-m.pmtuSem
+				m.pmtu <- &pmtuResult{host, mtu, err}
+			}(pr.opt, host, ip)
+		}
+	}
+}
+
+//更新主机的路径MTU，低于期望值或者恢复时发送通知
+func (m *monitor) updatePMTU(r *pmtuResult) {
+	host := r.host
+	delete(m.pmtuBusy, host)
+	if r.err != nil {
+		m.logger.Printf("[ERROR] area: %s, %s pmtu discovery failed: %s\n", host.Area, host.Name, r.err)
+		return
+	}
+	m.debug("[DEBUG] area: %s, %s pmtu %d\n", host.Area, host.Name, r.mtu)
+	last := host.PMTU
+	host.PMTU = r.mtu
+	low := r.mtu < host.PMTUExpect
+	//第一次探测正常时不通知
+	if last == 0 && !low {
+		return
+	}
+	if last == 0 || (last < host.PMTUExpect) != low {
+		m.logger.Printf("[WARN] %s, pmtu %d expect %d\n", host, r.mtu, host.PMTUExpect)
+		m.sendNotice(host, noticePMTU)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//企业微信markdown消息的最大长度(字节)
+const wecomMaxLen = 4000
+
+//通知内容转换为markdown: 与SendMail的HTML内容相同
+func (ev *Event) markdown() string {
+	var format = "2006-01-02 15:04:05"
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s\n\n", ev.Subject)
+	for _, o := range ev.Outages {
+		fmt.Fprintf(&b, "**站点故障: %s %d/%d 台离线 (%d%%)**\n\n",
+			o.Name, len(o.Down), o.Total, len(o.Down)*100/o.Total)
+		for _, v := range o.Down {
+			fmt.Fprintf(&b, "- %s %s 最近在线: %s\n", v.Name, v.Address, v.Last.Format(format))
+		}
+		b.WriteString("\n")
+	}
+	for i, v := range ev.rest() {
+		switch v.Kind {
+		case noticePMTU:
+			status := "**路径MTU恢复**"
+			if v.PMTU < v.PMTUExpect {
+				status = "**路径MTU过低**"
+			}
+			fmt.Fprintf(&b, "%d. %s %s %s\n   路径MTU: %d (期望 %d)\n",
+				i+1, v.Name, v.Address, status, v.PMTU, v.PMTUExpect)
+		case noticeGroup:
+			gh := v.Health
+			fmt.Fprintf(&b, "- %s **%s** (之前: %s)\n   在线 %d，离线 %d，总数 %d\n   时间: %s\n",
+				v.Name, v.To, v.From, gh.Up, gh.Down, gh.Total, v.Last.Format(format))
+		case noticeTrace:
+			fmt.Fprintf(&b, "%d. %s %s **离线**\n   最近在线: %s\n",
+				i+1, v.Name, v.Address, v.Last.Format(format))
+			if v.Trace != nil {
+				fmt.Fprintf(&b, "\n```\n%s```\n", v.Trace)
+			}
+		default:
+			if v.up() {
+				fmt.Fprintf(&b, "%d. %s %s **上线**\n   恢复时间: %s\n",
+					i+1, v.Name, v.Address, v.Last.Format(format))
+				if !v.DownSince.IsZero() && v.UpSince.After(v.DownSince) {
+					fmt.Fprintf(&b, "   离线 %s (%s 至 %s)\n", v.UpSince.Sub(v.DownSince).Truncate(time.Second),
+						v.DownSince.Format(format), v.UpSince.Format(format))
+				}
+			} else {
+				fmt.Fprintf(&b, "%d. %s %s **离线**\n   最近在线: %s\n",
+					i+1, v.Name, v.Address, v.Last.Format(format))
+				if v.Remind > 0 {
+					fmt.Fprintf(&b, "   第 %d 次提醒，已离线 %s\n", v.Remind, time.Since(v.AlertAt).Truncate(time.Minute))
+				}
+				if len(v.Unreachable) > 0 {
+					fmt.Fprintf(&b, "   下游不可达 %d 台: %s\n", len(v.Unreachable), strings.Join(v.Unreachable, ", "))
+				}
+			}
+			if v.PMTUExpect > 0 && v.PMTU > 0 {
+				fmt.Fprintf(&b, "   路径MTU: %d (期望 %d)\n", v.PMTU, v.PMTUExpect)
+			}
+		}
+	}
+	return b.String()
+}
+
+//截断到n字节以内: 优先在换行处截断，不截断UTF-8字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if i := strings.LastIndex(s[:n], "\n"); i > 0 {
+		return s[:i] + "\n..."
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "\n..."
+}
+
+//机器人的响应: 钉钉和企业微信为errcode，飞书为code
+type robotResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+func checkRobotResponse(b []byte) error {
+	var r robotResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("response %q: %s", b, err)
+	}
+	if r.ErrCode != 0 {
+		return fmt.Errorf("errcode %d: %s", r.ErrCode, r.ErrMsg)
+	}
+	if r.Code != 0 {
+		return fmt.Errorf("code %d: %s", r.Code, r.Msg)
+	}
+	return nil
+}
+
+//HMAC-SHA256签名，base64
+func signBase64(key, msg string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(msg))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//钉钉群机器人: 设置secret时使用加签
+type dingtalkNotifier struct {
+	c *NotifierConfig
+}
+
+func (n *dingtalkNotifier) Notify(ev *Event) error {
+	u := n.c.URL
+	if n.c.Secret != "" {
+		ts := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+		sign := signBase64(n.c.Secret, ts+"\n"+n.c.Secret)
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + "timestamp=" + ts + "&sign=" + url.QueryEscape(sign)
+	}
+	msg := map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": ev.Subject,
+			"text":  ev.markdown(),
+		},
+	}
+	b, err := postJSON(n.c, u, n.c.Headers, msg)
+	if err != nil {
+		return err
+	}
+	return checkRobotResponse(b)
+}
+
+//企业微信群机器人
+type wecomNotifier struct {
+	c *NotifierConfig
+}
+
+func (n *wecomNotifier) Notify(ev *Event) error {
+	msg := map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"content": truncate(ev.markdown(), wecomMaxLen),
+		},
+	}
+	b, err := postJSON(n.c, n.c.URL, n.c.Headers, msg)
+	if err != nil {
+		return err
+	}
+	return checkRobotResponse(b)
+}
+
+//飞书自定义机器人: 使用消息卡片，设置secret时签名
+type feishuNotifier struct {
+	c *NotifierConfig
+}
+
+func (n *feishuNotifier) Notify(ev *Event) error {
+	//全部恢复时为绿色
+	template := "green"
+	if !ev.ok() {
+		template = "red"
+	}
+	msg := map[string]interface{}{
+		"msg_type": "interactive",
+		"card": map[string]interface{}{
+			"header": map[string]interface{}{
+				"title":    map[string]string{"tag": "plain_text", "content": ev.Subject},
+				"template": template,
+			},
+			"elements": []interface{}{
+				map[string]string{"tag": "markdown", "content": ev.markdown()},
+			},
+		},
+	}
+	if n.c.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		msg["timestamp"] = ts
+		msg["sign"] = signBase64(ts+"\n"+n.c.Secret, "")
+	}
+	b, err := postJSON(n.c, n.c.URL, n.c.Headers, msg)
+	if err != nil {
+		return err
+	}
+	return checkRobotResponse(b)
+}
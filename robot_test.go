@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDingtalkSign(t *testing.T) {
+	s := newTestServer(t, 200, `{"errcode":0,"errmsg":"ok"}`)
+	c := &NotifierConfig{Name: "dt", URL: s.URL + "/robot/send?access_token=abc", Secret: "SECxyz"}
+	if err := (&dingtalkNotifier{c}).Notify(testEvent(stateDown)); err != nil {
+		t.Fatal(err)
+	}
+	req, body := s.last()
+	q := req.URL.Query()
+	if q.Get("access_token") != "abc" {
+		t.Errorf("access_token = %q", q.Get("access_token"))
+	}
+	ts := q.Get("timestamp")
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(0, ms*int64(time.Millisecond))) > time.Minute {
+		t.Fatalf("timestamp = %q", ts)
+	}
+	if want := signBase64("SECxyz", ts+"\nSECxyz"); q.Get("sign") != want {
+		t.Errorf("sign = %q, want %q", q.Get("sign"), want)
+	}
+
+	var msg struct {
+		MsgType  string `json:"msgtype"`
+		Markdown struct {
+			Title string `json:"title"`
+			Text  string `json:"text"`
+		} `json:"markdown"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgType != "markdown" || msg.Markdown.Title == "" || !strings.Contains(msg.Markdown.Text, "router 10.0.0.1 **离线**") {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestDingtalkNoSecret(t *testing.T) {
+	s := newTestServer(t, 200, `{"errcode":0}`)
+	c := &NotifierConfig{Name: "dt", URL: s.URL}
+	if err := (&dingtalkNotifier{c}).Notify(testEvent(stateDown)); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := s.last(); req.URL.RawQuery != "" {
+		t.Errorf("query = %q, want empty", req.URL.RawQuery)
+	}
+}
+
+func TestRobotError(t *testing.T) {
+	s := newTestServer(t, 200, `{"errcode":310000,"errmsg":"sign not match"}`)
+	c := &NotifierConfig{Name: "dt", URL: s.URL, Secret: "x"}
+	err := (&dingtalkNotifier{c}).Notify(testEvent(stateDown))
+	if err == nil || !strings.Contains(err.Error(), "310000") {
+		t.Errorf("err = %v, want errcode 310000", err)
+	}
+
+	s = newTestServer(t, 200, `{"code":19021,"msg":"sign match fail"}`)
+	c = &NotifierConfig{Name: "fs", URL: s.URL}
+	err = (&feishuNotifier{c}).Notify(testEvent(stateDown))
+	if err == nil || !strings.Contains(err.Error(), "19021") {
+		t.Errorf("err = %v, want code 19021", err)
+	}
+}
+
+func TestFeishuSign(t *testing.T) {
+	for _, tc := range []struct {
+		state    string
+		template string
+	}{
+		{stateDown, "red"},
+		{stateUp, "green"},
+	} {
+		s := newTestServer(t, 200, `{"code":0,"msg":"success"}`)
+		c := &NotifierConfig{Name: "fs", URL: s.URL, Secret: "fsecret"}
+		if err := (&feishuNotifier{c}).Notify(testEvent(tc.state)); err != nil {
+			t.Fatal(err)
+		}
+		var msg struct {
+			Timestamp string `json:"timestamp"`
+			Sign      string `json:"sign"`
+			MsgType   string `json:"msg_type"`
+			Card      struct {
+				Header struct {
+					Template string `json:"template"`
+				} `json:"header"`
+			} `json:"card"`
+		}
+		_, body := s.last()
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		sec, err := strconv.ParseInt(msg.Timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sec, 0)) > time.Minute {
+			t.Fatalf("timestamp = %q", msg.Timestamp)
+		}
+		//飞书的签名密钥为timestamp+"\n"+secret，内容为空
+		if want := signBase64(msg.Timestamp+"\nfsecret", ""); msg.Sign != want {
+			t.Errorf("sign = %q, want %q", msg.Sign, want)
+		}
+		if msg.MsgType != "interactive" || msg.Card.Header.Template != tc.template {
+			t.Errorf("%s: body = %s", tc.state, body)
+		}
+	}
+}
+
+func TestWecomTruncate(t *testing.T) {
+	s := newTestServer(t, 200, `{"errcode":0}`)
+	ev := testEvent(stateDown)
+	for i := 0; i < 200; i++ {
+		ev.Transitions = append(ev.Transitions, ev.Transitions[0])
+	}
+	c := &NotifierConfig{Name: "wc", URL: s.URL}
+	if err := (&wecomNotifier{c}).Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Markdown struct {
+			Content string `json:"content"`
+		} `json:"markdown"`
+	}
+	_, body := s.last()
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(msg.Markdown.Content); n > wecomMaxLen+4 || !strings.HasSuffix(msg.Markdown.Content, "\n...") {
+		t.Errorf("content length %d, want truncated to %d", n, wecomMaxLen)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"ab\ncdef", 5, "ab\n..."},
+		{"中文字符", 5, "中\n..."},
+	} {
+		if got := truncate(tc.s, tc.n); got != tc.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestMarkdownNotices(t *testing.T) {
+	ev := testPMTUEvent()
+	md := ev.markdown()
+	if !strings.Contains(md, "router 10.0.0.1 **路径MTU过低**\n   路径MTU: 1400 (期望 1500)") || strings.Contains(md, "上线") {
+		t.Errorf("pmtu: %q", md)
+	}
+
+	ev = testEvent(stateDown)
+	ev.Transitions[0].Kind = noticeTrace
+	ev.Transitions[0].Trace = &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}, {TTL: 2}}}
+	if md = ev.markdown(); !strings.Contains(md, "**离线**") || !strings.Contains(md, "```\n1 10.0.0.254 1ms\n2 *\n```") {
+		t.Errorf("trace: %q", md)
+	}
+
+	g := &Transition{Kind: noticeGroup, Key: "a1", Name: "site1", Area: "a1", Group: "site1", From: groupUp, To: groupPartial,
+		Last: time.Now(), Health: &groupHealth{State: groupPartial, Up: 3, Down: 1, Total: 4}}
+	ev = &Event{Area: "a1", Subject: "[a1] site1 - 区域状态变化通知: partial", Transitions: []*Transition{g}}
+	if md = ev.markdown(); !strings.Contains(md, "site1 **partial** (之前: up)\n   在线 3，离线 1，总数 4") {
+		t.Errorf("group: %q", md)
+	}
+}
+
+func TestFeishuNotices(t *testing.T) {
+	g := &Transition{Kind: noticeGroup, To: groupUp, Health: &groupHealth{}}
+	up := &Transition{Kind: noticePMTU, PMTU: 1500, PMTUExpect: 1500}
+	low := &Transition{Kind: noticePMTU, PMTU: 1400, PMTUExpect: 1500}
+	for _, tc := range []struct {
+		ts       []*Transition
+		template string
+	}{
+		{[]*Transition{g, up}, "green"},
+		{[]*Transition{low}, "red"},
+	} {
+		s := newTestServer(t, 200, `{"code":0}`)
+		c := &NotifierConfig{Name: "fs", URL: s.URL}
+		if err := (&feishuNotifier{c}).Notify(&Event{Transitions: tc.ts}); err != nil {
+			t.Fatal(err)
+		}
+		if _, body := s.last(); !strings.Contains(string(body), `"template":"`+tc.template+`"`) {
+			t.Errorf("body = %s, want template %s", body, tc.template)
+		}
+	}
+}
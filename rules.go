@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	//报警规则每次执行的最大步数
+	ruleMaxSteps = 100000
+	//保存的RTT记录数，-1表示超时
+	rttHistory = 20
+)
+
+//报警级别，由低到高
+var severities = []string{"info", "warning", "critical"}
+
+func severityLevel(s string) int {
+	for i, v := range severities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+//报警规则: etc/rules/*.star中定义的alert(host, prev, history, group)函数，
+//返回None或False不报警，返回True或者{"severity": "critical", "to": ["ops@example.com"]}报警
+type ruleSet struct {
+	dir string
+	//规则文件的修改时间，变化时重新加载
+	mtimes map[string]time.Time
+	rules  map[string]starlark.Value
+}
+
+//报警规则的执行结果
+type ruleResult struct {
+	Alert    bool
+	Severity string
+	To       []string
+}
+
+func newRuleSet(dir string) *ruleSet {
+	return &ruleSet{dir: dir, mtimes: make(map[string]time.Time), rules: make(map[string]starlark.Value)}
+}
+
+//规则文件有变化时重新加载: 出错的文件继续使用之前的规则
+func (rs *ruleSet) reload(m *monitor) {
+	files, err := filepath.Glob(filepath.Join(rs.dir, "*.star"))
+	if err != nil {
+		return
+	}
+	mtimes := make(map[string]time.Time)
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			mtimes[f] = fi.ModTime()
+		}
+	}
+	for f := range rs.mtimes {
+		if _, ok := mtimes[f]; !ok {
+			delete(rs.rules, f)
+			m.logger.Printf("[INFO] rule %s removed\n", f)
+		}
+	}
+	for f, mt := range mtimes {
+		if old, ok := rs.mtimes[f]; ok && old.Equal(mt) {
+			continue
+		}
+		fn, err := loadRule(m, f)
+		if err != nil {
+			m.logger.Printf("[ERROR] load rule %s: %s\n", f, err)
+			continue
+		}
+		rs.rules[f] = fn
+		m.logger.Printf("[INFO] rule %s loaded\n", f)
+	}
+	rs.mtimes = mtimes
+}
+
+func newThread(m *monitor, name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			m.logger.Printf("[RULE] %s: %s\n", name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(ruleMaxSteps)
+	return thread
+}
+
+//读取规则文件并返回其中的alert函数
+func loadRule(m *monitor, file string) (starlark.Value, error) {
+	globals, err := starlark.ExecFile(newThread(m, file), file, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["alert"]
+	if !ok {
+		return nil, fmt.Errorf("function alert not defined")
+	}
+	if _, ok := fn.(starlark.Callable); !ok {
+		return nil, fmt.Errorf("alert is not a function")
+	}
+	return fn, nil
+}
+
+//主机状态转换为starlark struct
+func hostValue(h *Host, state string) starlark.Value {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"name":    starlark.String(h.Name),
+		"address": starlark.String(h.Addr),
+		"area":    starlark.String(h.AreaID),
+		"state":   starlark.String(state),
+		"up":      starlark.Bool(state == stateUp),
+		"rtt":     starlark.String(h.RTT),
+		"failed":  starlark.MakeInt(h.Times),
+		"last":    starlark.String(h.Last.Format(time.RFC3339)),
+		"parent":  starlark.String(h.Parent),
+		"pmtu":    starlark.MakeInt(h.PMTU),
+	})
+}
+
+func groupValue(g *Group) starlark.Value {
+	if g == nil {
+		return starlark.None
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"area":  starlark.String(g.Area),
+		"name":  starlark.String(g.Name),
+		"email": starlark.String(g.Email),
+		"hosts": starlark.MakeInt(len(g.Hosts)),
+	})
+}
+
+//RTT记录，单位毫秒，-1表示超时
+func historyValue(rtts []time.Duration) starlark.Value {
+	var list []starlark.Value
+	for _, rtt := range rtts {
+		if rtt < 0 {
+			list = append(list, starlark.Float(-1))
+		} else {
+			list = append(list, starlark.Float(float64(rtt)/float64(time.Millisecond)))
+		}
+	}
+	return starlark.NewList(list)
+}
+
+//执行全部规则: 任一规则报警即报警，使用最高的级别并合并接收人；没有规则时报警
+func (rs *ruleSet) eval(m *monitor, h *Host, prev string) *ruleResult {
+	if len(rs.rules) == 0 {
+		return &ruleResult{Alert: true}
+	}
+	args := starlark.Tuple{
+		hostValue(h, h.State),
+		hostValue(h, prev),
+		historyValue(h.rtts),
+		groupValue(m.cfg.Groups[h.AreaID]),
+	}
+
+	var files []string
+	for f := range rs.rules {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var res = &ruleResult{}
+	var errs int
+	for _, f := range files {
+		v, err := starlark.Call(newThread(m, f), rs.rules[f], args, nil)
+		if err != nil {
+			m.logger.Printf("[ERROR] rule %s: %s\n", f, err)
+			errs++
+			continue
+		}
+		r, err := parseRuleResult(v)
+		if err != nil {
+			m.logger.Printf("[ERROR] rule %s: %s\n", f, err)
+			errs++
+			continue
+		}
+		if !r.Alert {
+			continue
+		}
+		res.Alert = true
+		if severityLevel(r.Severity) > severityLevel(res.Severity) {
+			res.Severity = r.Severity
+		}
+		res.To = append(res.To, r.To...)
+	}
+	//全部规则出错时按默认方式报警
+	if errs == len(files) {
+		return &ruleResult{Alert: true}
+	}
+	return res
+}
+
+//解析alert函数的返回值
+func parseRuleResult(v starlark.Value) (*ruleResult, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return &ruleResult{}, nil
+	case starlark.Bool:
+		return &ruleResult{Alert: bool(v)}, nil
+	case *starlark.Dict:
+		r := &ruleResult{Alert: true}
+		if s, found, _ := v.Get(starlark.String("severity")); found {
+			sev, ok := starlark.AsString(s)
+			if !ok || severityLevel(sev) < 0 {
+				return nil, fmt.Errorf("severity must be one of %v", severities)
+			}
+			r.Severity = sev
+		}
+		if to, found, _ := v.Get(starlark.String("to")); found {
+			var list []string
+			if s, ok := starlark.AsString(to); ok {
+				list = append(list, s)
+			} else if l, ok := to.(*starlark.List); ok {
+				for i := 0; i < l.Len(); i++ {
+					s, ok := starlark.AsString(l.Index(i))
+					if !ok {
+						return nil, fmt.Errorf("to must be a string or a list of strings")
+					}
+					list = append(list, s)
+				}
+			} else {
+				return nil, fmt.Errorf("to must be a string or a list of strings")
+			}
+			//错误的地址会导致整个邮件发送失败
+			for _, s := range list {
+				a, err := mail.ParseAddress(s)
+				if err != nil {
+					return nil, fmt.Errorf("to %q: %s", s, err)
+				}
+				r.To = append(r.To, a.Address)
+			}
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("alert returned %s, want None, bool or dict", v.Type())
+}
+
+//记录RTT，超时为-1
+func (h *Host) addRTT(rtt time.Duration) {
+	h.rtts = append(h.rtts, rtt)
+	if len(h.rtts) > rttHistory {
+		h.rtts = h.rtts[len(h.rtts)-rttHistory:]
+	}
+}
+
+//状态变化时根据维护窗口和报警规则决定是否发送通知，返回是否发送
+func (m *monitor) notify(h *Host, prev string) bool {
+	//维护期间的离线在窗口结束时仍然离线才通知
+	h.maintDown = h.Maintenance && h.State == stateDown
+	if m.suppressed(h) {
+		m.suppressedRecovery(h, prev)
+		return false
+	}
+	r := m.rules.eval(m, h, prev)
+	if !r.Alert {
+		m.logger.Printf("[INFO] %s, suppressed by rules\n", h)
+		m.suppressedRecovery(h, prev)
+		return false
+	}
+	h.Severity, h.rcpt = r.Severity, r.To
+	m.startEscalation(h)
+	m.mail <- newTransition(h, prev)
+	return true
+}
+
+//被抑制的恢复仍然发送给保存报警状态的渠道，避免外部系统中的报警一直不结束
+func (m *monitor) suppressedRecovery(h *Host, prev string) {
+	if h.State != stateUp || prev == stateUp {
+		return
+	}
+	t := newTransition(h, prev)
+	t.Suppressed = true
+	m.mail <- t
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+func TestParseRuleResult(t *testing.T) {
+	dict := func(kv ...starlark.Value) *starlark.Dict {
+		d := starlark.NewDict(len(kv) / 2)
+		for i := 0; i < len(kv); i += 2 {
+			d.SetKey(kv[i], kv[i+1])
+		}
+		return d
+	}
+	list := func(vs ...starlark.Value) *starlark.List { return starlark.NewList(vs) }
+	s := func(v string) starlark.Value { return starlark.String(v) }
+
+	for _, tc := range []struct {
+		v    starlark.Value
+		want *ruleResult
+		err  string
+	}{
+		{starlark.None, &ruleResult{}, ""},
+		{starlark.False, &ruleResult{}, ""},
+		{starlark.True, &ruleResult{Alert: true}, ""},
+		{dict(), &ruleResult{Alert: true}, ""},
+		{dict(s("severity"), s("warning")), &ruleResult{Alert: true, Severity: "warning"}, ""},
+		{dict(s("to"), s("Ops <ops@example.com>")), &ruleResult{Alert: true, To: []string{"ops@example.com"}}, ""},
+		{dict(s("to"), list(s("a@example.com"), s("b@example.com"))), &ruleResult{Alert: true, To: []string{"a@example.com", "b@example.com"}}, ""},
+		{dict(s("severity"), s("fatal")), nil, "severity"},
+		{dict(s("severity"), starlark.MakeInt(1)), nil, "severity"},
+		{dict(s("to"), list(s("a@example.com"), starlark.MakeInt(1))), nil, "list of strings"},
+		{dict(s("to"), starlark.MakeInt(1)), nil, "list of strings"},
+		{dict(s("to"), s("not an address")), nil, "not an address"},
+		{starlark.MakeInt(1), nil, "want None, bool or dict"},
+	} {
+		got, err := parseRuleResult(tc.v)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: err = %v, want %q", tc.v, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, %v, want %+v", tc.v, got, err, tc.want)
+		}
+	}
+}
+
+func TestRuleSetEval(t *testing.T) {
+	dir := t.TempDir()
+	m := &monitor{logger: log.New(ioutil.Discard, "", 0), cfg: &Config{}}
+	rs := newRuleSet(dir)
+	h := &Host{Name: "sw1", Addr: "10.0.0.1", AreaID: "a1", State: stateDown, Last: time.Now()}
+
+	//没有规则时报警
+	rs.reload(m)
+	if r := rs.eval(m, h, stateUp); !r.Alert {
+		t.Error("no rules: want alert")
+	}
+
+	write := func(name, src string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.star", `
+def alert(host, prev, history, group):
+    if host.up:
+        return False
+    return {"severity": "warning", "to": ["a@example.com"]}
+`)
+	write("b.star", `
+def alert(host, prev, history, group):
+    if host.name == "sw1" and prev.state == "up":
+        return {"severity": "critical", "to": "b@example.com"}
+    return None
+`)
+	//加载失败的规则被忽略
+	write("c.star", "def alert(:\n")
+	rs.reload(m)
+	if len(rs.rules) != 2 {
+		t.Fatalf("loaded %d rules, want 2", len(rs.rules))
+	}
+
+	r := rs.eval(m, h, stateUp)
+	want := &ruleResult{Alert: true, Severity: "critical", To: []string{"a@example.com", "b@example.com"}}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("down: got %+v, want %+v", r, want)
+	}
+	h.State = stateUp
+	if r := rs.eval(m, h, stateDown); r.Alert {
+		t.Errorf("up: got %+v, want no alert", r)
+	}
+	h.State = stateDown
+
+	//全部规则出错时按默认方式报警
+	write("a.star", "def alert(host, prev, history, group):\n    return 1\n")
+	write("b.star", "def alert(host, prev, history, group):\n    return host.missing\n")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "a.star"), future, future)
+	os.Chtimes(filepath.Join(dir, "b.star"), future, future)
+	rs.reload(m)
+	if r := rs.eval(m, h, stateUp); !reflect.DeepEqual(r, &ruleResult{Alert: true}) {
+		t.Errorf("all rules failed: got %+v, want default alert", r)
+	}
+
+	//删除的规则不再执行
+	os.Remove(filepath.Join(dir, "a.star"))
+	os.Remove(filepath.Join(dir, "b.star"))
+	rs.reload(m)
+	if len(rs.rules) != 0 {
+		t.Errorf("%d rules left after removal", len(rs.rules))
+	}
+}
+
+func TestAddRcpt(t *testing.T) {
+	for _, tc := range []struct {
+		rcpt string
+		to   []string
+		want string
+	}{
+		{"", []string{"a@example.com"}, "a@example.com"},
+		{"A@Example.com", []string{"a@example.com", "b@example.com"}, "A@Example.com,b@example.com"},
+		{"a@example.com", []string{"Bob <b@example.com>, c@example.com", "b@example.com"}, "a@example.com,b@example.com,c@example.com"},
+		{"a@example.com", []string{"bad address"}, "a@example.com"},
+	} {
+		if got := addRcpt(tc.rcpt, tc.to); got != tc.want {
+			t.Errorf("addRcpt(%q, %q) = %q, want %q", tc.rcpt, tc.to, got, tc.want)
+		}
+	}
+}
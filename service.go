@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//业务服务通知使用的区域: 组的area不能以@开头，不会与组冲突
+	serviceArea = "@services"
+	//保存的状态变化记录数
+	serviceHistory = 50
+)
+
+//业务服务: 由主机状态组成的表达式，如"2 of (web1, web2, web3) AND db1"
+type Service struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+}
+
+//业务服务的状态变化记录
+type serviceEvent struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+}
+
+//业务服务状态: /status/services
+type serviceStatus struct {
+	Name    string          `json:"name"`
+	Expr    string          `json:"expr"`
+	State   string          `json:"state"`
+	Since   time.Time       `json:"since"`
+	History []*serviceEvent `json:"history"`
+
+	node svcNode
+	//通过邮件通知使用的主机
+	host *Host
+}
+
+//表达式节点: up返回area/name对应主机是否在线
+type svcNode interface {
+	eval(up func(string) bool) bool
+}
+
+type svcHost string
+
+func (n svcHost) eval(up func(string) bool) bool {
+	return up(string(n))
+}
+
+type svcNot struct{ n svcNode }
+
+func (n *svcNot) eval(up func(string) bool) bool {
+	return !n.n.eval(up)
+}
+
+type svcAnd []svcNode
+
+func (n svcAnd) eval(up func(string) bool) bool {
+	for _, c := range n {
+		if !c.eval(up) {
+			return false
+		}
+	}
+	return true
+}
+
+type svcOr []svcNode
+
+func (n svcOr) eval(up func(string) bool) bool {
+	for _, c := range n {
+		if c.eval(up) {
+			return true
+		}
+	}
+	return false
+}
+
+//至少n个在线
+type svcQuorum struct {
+	n    int
+	list []svcNode
+}
+
+func (n *svcQuorum) eval(up func(string) bool) bool {
+	var count int
+	for _, c := range n.list {
+		if c.eval(up) {
+			count++
+		}
+	}
+	return count >= n.n
+}
+
+//表达式解析: resolve将主机名称转换为area/name
+type svcParser struct {
+	tokens  []string
+	pos     int
+	resolve func(string) (string, error)
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	var cur string
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == ',':
+			if cur != "" {
+				tokens = append(tokens, cur)
+				cur = ""
+			}
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n':
+			if cur != "" {
+				tokens = append(tokens, cur)
+				cur = ""
+			}
+		default:
+			cur += string(r)
+		}
+	}
+	if cur != "" {
+		tokens = append(tokens, cur)
+	}
+	return tokens
+}
+
+//解析业务服务表达式:
+//expr := term {OR term}; term := factor {AND factor};
+//factor := NOT factor | "(" expr ")" | N of "(" expr {"," expr} ")" | host
+func parseService(s string, resolve func(string) (string, error)) (svcNode, error) {
+	p := &svcParser{tokens: tokenize(s), resolve: resolve}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return n, nil
+}
+
+func (p *svcParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *svcParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *svcParser) expect(t string) error {
+	if got := p.next(); !strings.EqualFold(got, t) {
+		return fmt.Errorf("expect %q, got %q", t, got)
+	}
+	return nil
+}
+
+func (p *svcParser) expr() (svcNode, error) {
+	n, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	or := svcOr{n}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		n, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, n)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *svcParser) term() (svcNode, error) {
+	n, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	and := svcAnd{n}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		n, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, n)
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *svcParser) factor() (svcNode, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case strings.EqualFold(t, "not"):
+		n, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return &svcNot{n}, nil
+	case t == "(":
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case t == ")" || t == ",":
+		return nil, fmt.Errorf("unexpected %q", t)
+	}
+
+	//N of (...)
+	if n, err := strconv.Atoi(t); err == nil && strings.EqualFold(p.peek(), "of") {
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		q := &svcQuorum{n: n}
+		for {
+			c, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			q.list = append(q.list, c)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		if n < 1 || n > len(q.list) {
+			return nil, fmt.Errorf("%d of %d hosts", n, len(q.list))
+		}
+		return q, p.expect(")")
+	}
+
+	key, err := p.resolve(t)
+	if err != nil {
+		return nil, err
+	}
+	return svcHost(key), nil
+}
+
+//主机名称转换为area/name: 名称在全部分组中必须唯一
+func hostResolver(groups map[string]*Group) func(string) (string, error) {
+	return func(name string) (string, error) {
+		var keys []string
+		for area, g := range groups {
+			for _, h := range g.Hosts {
+				if hostKey(area, h.Name) == name || h.Name == name {
+					keys = append(keys, hostKey(area, h.Name))
+				}
+			}
+		}
+		switch len(keys) {
+		case 0:
+			return "", fmt.Errorf("host %s not found", name)
+		case 1:
+			return keys[0], nil
+		}
+		return "", fmt.Errorf("host %s is ambiguous: %s", name, strings.Join(keys, ", "))
+	}
+}
+
+//检查业务服务配置
+func checkServices(services []*Service, groups map[string]*Group) error {
+	names := make(map[string]bool)
+	for _, s := range services {
+		if s.Name == "" || names[s.Name] {
+			return fmt.Errorf("service name %q is empty or duplicate", s.Name)
+		}
+		names[s.Name] = true
+		if _, err := parseService(s.Expr, hostResolver(groups)); err != nil {
+			return fmt.Errorf("service %s: %s", s.Name, err)
+		}
+	}
+	return nil
+}
+
+//创建业务服务状态，配置已经在读取时检查
+func newServices(services []*Service, groups map[string]*Group) []*serviceStatus {
+	var list []*serviceStatus
+	for _, s := range services {
+		node, err := parseService(s.Expr, hostResolver(groups))
+		if err != nil {
+			continue
+		}
+		list = append(list, &serviceStatus{
+			Name:    s.Name,
+			Expr:    s.Expr,
+			State:   stateDown,
+			History: make([]*serviceEvent, 0),
+			node:    node,
+			host: &Host{
+				Name:   s.Name,
+				State:  stateDown,
+				AreaID: serviceArea,
+				Area:   "业务服务",
+			},
+		})
+	}
+	return list
+}
+
+//根据主机状态计算业务服务状态，变化时通过邮件通知；
+//启动后全部探测完成一轮之前主机状态未知，不计算
+func (m *monitor) updateServices() {
+	if len(m.idle) < len(m.probes) {
+		return
+	}
+	hosts := make(map[string]*Host)
+	for _, h := range m.cfg.Hosts {
+		hosts[hostKey(h.AreaID, h.Name)] = h
+	}
+	up := func(key string) bool {
+		h, ok := hosts[key]
+		return ok && h.Stat
+	}
+	//维护中的主机按在线计算
+	upOrMaint := func(key string) bool {
+		h, ok := hosts[key]
+		return ok && (h.Stat || h.Maintenance)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.services {
+		state := stateDown
+		if s.node.eval(up) {
+			state = stateUp
+			s.host.Last = time.Now()
+		}
+		//离线只是因为成员主机在维护中时不通知，维护结束后仍然离线再通知
+		maint := state == stateDown && s.node.eval(upOrMaint)
+		if state == s.State {
+			if s.host.Maintenance && !maint {
+				s.host.Maintenance = false
+				if s.host.maintDown {
+					m.notify(s.host, stateUp)
+				}
+			}
+			continue
+		}
+		last, prev := s.Since, s.State
+		s.State, s.Since = state, time.Now()
+		s.History = append(s.History, &serviceEvent{s.Since, state})
+		if len(s.History) > serviceHistory {
+			s.History = s.History[len(s.History)-serviceHistory:]
+		}
+		s.host.Stat, s.host.State = state == stateUp, state
+		if s.host.Stat {
+			s.host.UpSince = s.Since
+		} else {
+			s.host.DownSince = s.Since
+		}
+		m.logger.Printf("[INFO] %s\n", s.host)
+		m.publishState(s.host)
+		//启动后第一次在线和没有通知过的离线恢复时不通知
+		if (last.IsZero() || s.host.maintDown) && state == stateUp {
+			s.host.Maintenance, s.host.maintDown = false, false
+			continue
+		}
+		s.host.Maintenance = maint
+		m.notify(s.host, prev)
+		if s.host.Stat {
+			m.stopEscalation(s.host)
+		}
+	}
+}
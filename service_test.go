@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testGroups() map[string]*Group {
+	return map[string]*Group{
+		"a1": {Area: "a1", Name: "site1", Hosts: []*jsonhost{{Name: "web1"}, {Name: "web2"}, {Name: "web3"}, {Name: "db"}}},
+		"a2": {Area: "a2", Name: "site2", Hosts: []*jsonhost{{Name: "db"}, {Name: "lb"}}},
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("2 of(web1,web2)\tAND not\n(a1/db)")
+	want := []string{"2", "of", "(", "web1", ",", "web2", ")", "AND", "not", "(", "a1/db", ")"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestParseService(t *testing.T) {
+	resolve := hostResolver(testGroups())
+	for _, tc := range []struct {
+		expr string
+		up   []string
+		want bool
+	}{
+		{"web1", []string{"a1/web1"}, true},
+		{"web1", nil, false},
+		//AND的优先级高于OR
+		{"web1 or web2 and lb", []string{"a1/web1"}, true},
+		{"(web1 or web2) and lb", []string{"a1/web1"}, false},
+		{"(web1 OR web2) AND lb", []string{"a1/web1", "a2/lb"}, true},
+		{"not a1/db", nil, true},
+		{"NOT NOT a1/db", []string{"a1/db"}, true},
+		{"2 of (web1, web2, web3) and a2/db", []string{"a1/web1", "a1/web3", "a2/db"}, true},
+		{"2 of (web1, web2, web3) and a2/db", []string{"a1/web1", "a2/db"}, false},
+		{"1 of (web1 and web2, lb)", []string{"a1/web1"}, false},
+		{"1 of (web1 and web2, lb)", []string{"a1/web1", "a1/web2"}, true},
+	} {
+		n, err := parseService(tc.expr, resolve)
+		if err != nil {
+			t.Errorf("%q: %s", tc.expr, err)
+			continue
+		}
+		up := func(key string) bool {
+			for _, k := range tc.up {
+				if k == key {
+					return true
+				}
+			}
+			return false
+		}
+		if got := n.eval(up); got != tc.want {
+			t.Errorf("%q with %v up = %v, want %v", tc.expr, tc.up, got, tc.want)
+		}
+	}
+}
+
+func TestParseServiceError(t *testing.T) {
+	resolve := hostResolver(testGroups())
+	for _, tc := range []struct {
+		expr string
+		err  string
+	}{
+		{"", "unexpected end"},
+		{"web1 and", "unexpected end"},
+		{"(web1 or web2", `expect ")"`},
+		{"web1 web2", `unexpected "web2"`},
+		{"web1 )", `unexpected ")"`},
+		{", web1", `unexpected ","`},
+		{"3 of (web1, web2)", "3 of 2 hosts"},
+		{"0 of (web1)", "0 of 1 hosts"},
+		{"2 of web1", `expect "("`},
+		{"db", "ambiguous"},
+		{"mail", "not found"},
+	} {
+		_, err := parseService(tc.expr, resolve)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: err = %v, want %q", tc.expr, err, tc.err)
+		}
+	}
+}
+
+func TestCheckServices(t *testing.T) {
+	groups := testGroups()
+	for _, tc := range []struct {
+		services []*Service
+		ok       bool
+	}{
+		{[]*Service{{Name: "shop", Expr: "lb and a1/db"}, {Name: "web", Expr: "web1"}}, true},
+		{[]*Service{{Name: "shop", Expr: "lb"}, {Name: "shop", Expr: "web1"}}, false},
+		{[]*Service{{Expr: "lb"}}, false},
+		{[]*Service{{Name: "shop", Expr: "lb and"}}, false},
+	} {
+		if err := checkServices(tc.services, groups); (err == nil) != tc.ok {
+			t.Errorf("%v: err = %v", tc.services, err)
+		}
+	}
+}
+
+func TestUpdateServicesStoppedProbe(t *testing.T) {
+	groups := testGroups()
+	web1 := &Host{Name: "web1", AreaID: "a1", Stat: true}
+	running, dead := &probe{hosts: map[string]*Host{"10.0.0.1": web1}}, &probe{
+		ping:    NewPinger(probeOption{}),
+		hosts:   map[string]*Host{},
+		results: map[string]*response{},
+	}
+	m := &monitor{
+		mail:     make(chan *Transition, 1),
+		logger:   log.New(ioutil.Discard, "", 0),
+		cfg:      &Config{Hosts: []*Host{web1}, Groups: groups},
+		probes:   []*probe{running, dead},
+		idle:     map[*probe]bool{running: true},
+		services: newServices([]*Service{{Name: "web", Expr: "web1"}}, groups),
+	}
+	//还有probe没有完成一轮探测
+	m.updateServices()
+	if m.services[0].State != stateDown || !m.services[0].Since.IsZero() {
+		t.Fatalf("evaluated before all probes finished: %+v", m.services[0])
+	}
+	//probe停止后不再等待
+	m.stopped(dead)
+	m.updateServices()
+	if m.services[0].State != stateUp {
+		t.Errorf("state %s after probe stopped, want up", m.services[0].State)
+	}
+}
+
+func TestUpdateServicesMaintenance(t *testing.T) {
+	groups := testGroups()
+	web1 := &Host{Name: "web1", AreaID: "a1", Stat: true}
+	lb := &Host{Name: "lb", AreaID: "a2", Stat: true}
+	pr := &probe{}
+	m := &monitor{
+		mail:     make(chan *Transition, 3),
+		logger:   log.New(ioutil.Discard, "", 0),
+		cfg:      &Config{Hosts: []*Host{web1, lb}, Groups: groups},
+		probes:   []*probe{pr},
+		idle:     map[*probe]bool{pr: true},
+		rules:    newRuleSet(""),
+		services: newServices([]*Service{{Name: "web", Expr: "web1 and lb"}}, groups),
+	}
+	m.updateServices()
+	//维护中的成员离线不通知，恢复时也不通知
+	web1.Maintenance, web1.Stat = true, false
+	m.updateServices()
+	web1.Stat = true
+	m.updateServices()
+	if len(m.mail) != 0 || m.services[0].State != stateUp {
+		t.Fatalf("%d mails, state %s", len(m.mail), m.services[0].State)
+	}
+	//维护结束后仍然离线时通知
+	web1.Stat = false
+	m.updateServices()
+	web1.Maintenance = false
+	m.updateServices()
+	if len(m.mail) != 1 || m.services[0].host.Maintenance {
+		t.Fatalf("%d mails after maintenance ended", len(m.mail))
+	}
+	//已经通知过的离线恢复时通知
+	web1.Stat = true
+	m.updateServices()
+	//不在维护中的成员离线时通知
+	web1.Maintenance, lb.Stat = true, false
+	m.updateServices()
+	if len(m.mail) != 3 {
+		t.Errorf("%d mails when lb down", len(m.mail))
+	}
+}
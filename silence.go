@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//http服务认证后传递给ping守护进程的用户名
+const userHeader = "X-Monitor-User"
+
+//确认: 主机恢复或者到期前不再发送离线通知
+type Ack struct {
+	By      string    `json:"by"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+	//为零时直到主机恢复
+	Expire time.Time `json:"expire"`
+}
+
+//静默: 到期前不发送主机(area/name)或者组(area)的通知
+type Silence struct {
+	ID      string    `json:"id"`
+	Target  string    `json:"target"`
+	By      string    `json:"by"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+	Expire  time.Time `json:"expire"`
+}
+
+//确认和静默请求: 在监控循环中处理
+type silenceRequest struct {
+	todo    string
+	target  string
+	id      string
+	by      string
+	comment string
+	d       time.Duration
+	reply   chan error
+}
+
+//处理确认和静默请求
+func (m *monitor) silence(req *silenceRequest) error {
+	now := time.Now()
+	var expire time.Time
+	if req.d > 0 {
+		expire = now.Add(req.d)
+	}
+
+	switch req.todo {
+	case "ack", "unack":
+		var host *Host
+		for _, h := range m.cfg.Hosts {
+			if hostKey(h.AreaID, h.Name) == req.target {
+				host = h
+			}
+		}
+		if host == nil {
+			return fmt.Errorf("host %s not found", req.target)
+		}
+		if req.todo == "unack" {
+			host.Ack = nil
+			m.logger.Printf("[INFO] %s, unacknowledged by %s\n", host, req.by)
+			return nil
+		}
+		if host.Stat {
+			return fmt.Errorf("host %s is up", req.target)
+		}
+		host.Ack = &Ack{By: req.by, Comment: req.comment, Time: now, Expire: expire}
+		m.logger.Printf("[INFO] %s, acknowledged by %s: %s\n", host, req.by, req.comment)
+
+	case "add":
+		if req.d <= 0 {
+			return errors.New("duration must be positive")
+		}
+		if !m.isTarget(req.target) {
+			return fmt.Errorf("host or group %s not found", req.target)
+		}
+		s := &Silence{
+			ID:      strconv.FormatInt(now.UnixNano(), 36),
+			Target:  req.target,
+			By:      req.by,
+			Comment: req.comment,
+			Time:    now,
+			Expire:  expire,
+		}
+		m.mu.Lock()
+		m.silences = append(m.silences, s)
+		m.mu.Unlock()
+		m.logger.Printf("[INFO] silence %s for %s by %s until %s: %s\n",
+			s.ID, s.Target, s.By, s.Expire.Format(time.RFC3339), s.Comment)
+
+	case "delete":
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, s := range m.silences {
+			if s.ID == req.id {
+				m.silences = append(m.silences[:i], m.silences[i+1:]...)
+				m.logger.Printf("[INFO] silence %s for %s deleted by %s\n", s.ID, s.Target, req.by)
+				return nil
+			}
+		}
+		return fmt.Errorf("silence %s not found", req.id)
+
+	default:
+		return fmt.Errorf("unknown todo %q", req.todo)
+	}
+	return nil
+}
+
+//静默的目标: 主机area/name、组area或者业务服务@services/name
+func (m *monitor) isTarget(target string) bool {
+	if _, ok := m.cfg.Groups[target]; ok {
+		return true
+	}
+	for _, h := range m.cfg.Hosts {
+		if hostKey(h.AreaID, h.Name) == target {
+			return true
+		}
+	}
+	for _, s := range m.services {
+		if hostKey(serviceArea, s.Name) == target {
+			return true
+		}
+	}
+	return false
+}
+
+//清除到期的静默和确认
+func (m *monitor) expireSilences() {
+	now := time.Now()
+	m.mu.Lock()
+	var keep []*Silence
+	for _, s := range m.silences {
+		if now.Before(s.Expire) {
+			keep = append(keep, s)
+		} else {
+			m.logger.Printf("[INFO] silence %s for %s expired\n", s.ID, s.Target)
+		}
+	}
+	m.silences = keep
+	m.mu.Unlock()
+
+	for _, h := range m.cfg.Hosts {
+		if h.Ack != nil && !h.Ack.Expire.IsZero() && !now.Before(h.Ack.Expire) {
+			m.logger.Printf("[INFO] %s, acknowledgement expired\n", h)
+			h.Ack = nil
+		}
+	}
+}
+
+//主机或者所在组的静默，只在监控循环中调用
+func (m *monitor) silenced(h *Host) *Silence {
+	return m.silencedTarget(hostKey(h.AreaID, h.Name), h.AreaID)
+}
+
+//目标中任一个的静默，只在监控循环中调用
+func (m *monitor) silencedTarget(targets ...string) *Silence {
+	now := time.Now()
+	for _, s := range m.silences {
+		if contains(targets, s.Target) && now.Before(s.Expire) {
+			return s
+		}
+	}
+	return nil
+}
+
+//维护、静默或者确认中的主机不发送通知
+func (m *monitor) suppressed(h *Host) bool {
+	if h.Maintenance {
+		m.logger.Printf("[INFO] %s, in maintenance\n", h)
+		return true
+	}
+	if s := m.silenced(h); s != nil {
+		m.logger.Printf("[INFO] %s, silenced by %s until %s\n", h, s.By, s.Expire.Format(time.RFC3339))
+		return true
+	}
+	if h.Ack != nil && !h.Stat {
+		m.logger.Printf("[INFO] %s, acknowledged by %s\n", h, h.Ack.By)
+		return true
+	}
+	return false
+}
+
+//确认和静默接口: 由http服务认证后转发
+//POST todo=ack|unack&host=area/name&comment=&duration=2h
+//GET 查询静默; POST todo=add&target=&comment=&duration=2h, todo=delete&id=
+func (m *monitor) silenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		var list = make([]*Silence, 0)
+		m.writeJson(w, r, append(list, m.silences...))
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &silenceRequest{
+		todo:    r.FormValue("todo"),
+		target:  r.FormValue("target"),
+		id:      r.FormValue("id"),
+		by:      r.Header.Get(userHeader),
+		comment: r.FormValue("comment"),
+		reply:   make(chan error, 1),
+	}
+	if h := r.FormValue("host"); h != "" {
+		req.target = h
+	}
+	if req.by == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s := r.FormValue("duration"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "duration: %s", err)
+			return
+		}
+		req.d = d
+	}
+
+	m.silenceReq <- req
+	if err := <-req.reply; err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json; charset=utf-8")
+	fmt.Fprintf(w, `{"%s":"OK"}`, req.todo)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSilence(t *testing.T) {
+	h := &Host{Name: "web1", AreaID: "a1", Area: "site1"}
+	m := &monitor{
+		logger: log.New(ioutil.Discard, "", 0),
+		cfg:    &Config{Hosts: []*Host{h}, Groups: map[string]*Group{"a1": {Area: "a1"}}},
+	}
+	if err := m.silence(&silenceRequest{todo: "add", target: "a2", d: time.Hour}); err == nil {
+		t.Error("silenced unknown group")
+	}
+	if err := m.silence(&silenceRequest{todo: "add", target: "a1"}); err == nil {
+		t.Error("silenced without duration")
+	}
+	if err := m.silence(&silenceRequest{todo: "add", target: "a1", by: "admin", d: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.silenced(h); s == nil || s.By != "admin" {
+		t.Fatalf("host not silenced by group: %v", s)
+	}
+	if err := m.silence(&silenceRequest{todo: "delete", id: m.silences[0].ID}); err != nil || m.silenced(h) != nil {
+		t.Fatalf("delete: %v, %v", err, m.silenced(h))
+	}
+
+	//在线的主机不能确认
+	h.Stat = true
+	if err := m.silence(&silenceRequest{todo: "ack", target: "a1/web1", by: "admin"}); err == nil {
+		t.Error("acknowledged host up")
+	}
+	h.Stat = false
+	if err := m.silence(&silenceRequest{todo: "ack", target: "a1/web1", by: "admin", d: time.Hour}); err != nil || h.Ack == nil {
+		t.Fatalf("ack: %v, %v", err, h.Ack)
+	}
+	if !m.suppressed(h) {
+		t.Error("acknowledged host not suppressed")
+	}
+	h.Ack.Expire = time.Now()
+	m.expireSilences()
+	if h.Ack != nil {
+		t.Error("acknowledgement not expired")
+	}
+}
+
+func TestUpdateGroupsSilence(t *testing.T) {
+	var buf bytes.Buffer
+	g := &Group{Area: "a", Name: "site a", Alert: &groupAlert{States: []string{groupDown}}}
+	hs := outageHosts("a", 1, 2)
+	for _, h := range hs {
+		h.Stat = true
+	}
+	m := &monitor{
+		cfg:      &Config{Times: 3, Hosts: hs, Groups: map[string]*Group{"a": g}},
+		groups:   make(map[string]*groupHealth),
+		logger:   log.New(&buf, "", 0),
+		silences: []*Silence{{ID: "s1", Target: "a", By: "admin", Expire: time.Now().Add(time.Hour)}},
+	}
+	m.updateGroups()
+	hs[0].Stat, hs[0].Times = false, 3
+	m.updateGroups()
+	//静默的组不发送组状态通知
+	if !strings.Contains(buf.String(), "group a, silenced by admin") {
+		t.Errorf("group mail not silenced: %s", buf.String())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Slack消息附件的颜色
+const (
+	slackDanger  = "danger"
+	slackGood    = "good"
+	slackWarning = "warning"
+)
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback string        `json:"fallback"`
+	Color    string        `json:"color"`
+	Title    string        `json:"title"`
+	Text     string        `json:"text,omitempty"`
+	Fields   []*slackField `json:"fields,omitempty"`
+	Ts       int64         `json:"ts"`
+}
+
+type slackMessage struct {
+	Channel     string             `json:"channel,omitempty"`
+	Username    string             `json:"username,omitempty"`
+	Text        string             `json:"text"`
+	Attachments []*slackAttachment `json:"attachments"`
+}
+
+//Slack兼容的incoming webhook: 也可用于Mattermost和Rocket.Chat，
+//每台主机一个附件，离线为红色，上线为绿色，站点故障合并为一个附件
+type slackNotifier struct {
+	c *NotifierConfig
+}
+
+func (n *slackNotifier) Notify(ev *Event) error {
+	var format = "2006-01-02 15:04:05"
+	msg := &slackMessage{
+		Channel:  n.c.Channel,
+		Username: n.c.Username,
+		Text:     ev.Subject,
+	}
+	for _, o := range ev.Outages {
+		var lines []string
+		for _, v := range o.Down {
+			lines = append(lines, fmt.Sprintf("%s %s last seen: %s", v.Name, v.Address, v.Last.Format(format)))
+		}
+		title := fmt.Sprintf("Site outage: %s %d/%d hosts down (%d%%)", o.Name, len(o.Down), o.Total, len(o.Down)*100/o.Total)
+		msg.Attachments = append(msg.Attachments, &slackAttachment{
+			Fallback: title,
+			Color:    slackDanger,
+			Title:    title,
+			Text:     strings.Join(lines, "\n"),
+			Ts:       ev.Time.Unix(),
+		})
+	}
+	for _, v := range ev.rest() {
+		a := &slackAttachment{
+			Color: slackDanger,
+			Title: fmt.Sprintf("%s (%s) %s", v.Name, v.Address, v.To),
+			Fields: []*slackField{
+				{Title: "Group", Value: fmt.Sprintf("%s (%s)", v.Group, v.Area), Short: true},
+				{Title: "State", Value: fmt.Sprintf("%s -> %s", v.From, v.To), Short: true},
+			},
+			Ts: ev.Time.Unix(),
+		}
+		if v.ok() {
+			a.Color = slackGood
+		}
+		switch v.Kind {
+		case noticePMTU:
+			a.Title = fmt.Sprintf("%s (%s) path MTU %d", v.Name, v.Address, v.PMTU)
+			a.Fields = a.Fields[:1]
+			if v.PMTU < v.PMTUExpect {
+				a.Color = slackWarning
+			}
+		case noticeGroup:
+			gh := v.Health
+			a.Title = fmt.Sprintf("%s %s", v.Name, v.To)
+			a.Fields = append(a.Fields[1:], &slackField{
+				Title: "Hosts",
+				Value: fmt.Sprintf("up %d, down %d, total %d", gh.Up, gh.Down, gh.Total),
+				Short: true,
+			})
+			if v.To == groupPartial {
+				a.Color = slackWarning
+			}
+		case noticeTrace:
+			a.Fields = append(a.Fields[:1], &slackField{Title: "Last seen", Value: v.Last.Format(format), Short: true})
+			if v.Trace != nil {
+				a.Text = "Traceroute:\n```\n" + v.Trace.String() + "```"
+			}
+		default:
+			if v.up() {
+				if !v.DownSince.IsZero() && v.UpSince.After(v.DownSince) {
+					a.Fields = append(a.Fields, &slackField{
+						Title: "Down for",
+						Value: fmt.Sprintf("%s (%s - %s)", v.UpSince.Sub(v.DownSince).Truncate(time.Second),
+							v.DownSince.Format(format), v.UpSince.Format(format)),
+					})
+				}
+			} else {
+				a.Fields = append(a.Fields, &slackField{Title: "Last seen", Value: v.Last.Format(format), Short: true})
+				if v.Remind > 0 {
+					a.Fields = append(a.Fields, &slackField{
+						Title: "Reminder",
+						Value: fmt.Sprintf("#%d, down for %s", v.Remind, time.Since(v.AlertAt).Truncate(time.Minute)),
+						Short: true,
+					})
+				}
+				if len(v.Unreachable) > 0 {
+					a.Fields = append(a.Fields, &slackField{Title: "Unreachable", Value: strings.Join(v.Unreachable, ", ")})
+				}
+			}
+		}
+		if v.PMTUExpect > 0 && v.PMTU > 0 {
+			a.Fields = append(a.Fields, &slackField{
+				Title: "Path MTU",
+				Value: fmt.Sprintf("%d (expected %d)", v.PMTU, v.PMTUExpect),
+				Short: true,
+			})
+		}
+		a.Fallback = a.Title
+		msg.Attachments = append(msg.Attachments, a)
+	}
+	_, err := postJSON(n.c, n.c.URL, n.c.Headers, msg)
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+//发送通知并解析Slack消息
+func slackNotify(t *testing.T, ev *Event) *slackMessage {
+	s := newTestServer(t, 200, "ok")
+	c := &NotifierConfig{Name: "slack", URL: s.URL, Channel: "#ops", Username: "goping"}
+	if err := (&slackNotifier{c}).Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	_, body := s.last()
+	var msg slackMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+//附件中的字段
+func slackValue(a *slackAttachment, title string) string {
+	for _, f := range a.Fields {
+		if f.Title == title {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func TestSlack(t *testing.T) {
+	msg := slackNotify(t, testEvent(stateDown))
+	if msg.Channel != "#ops" || msg.Username != "goping" || msg.Text != "[a1] site1 - 网络设备状态变化通知" || len(msg.Attachments) != 1 {
+		t.Fatalf("message %+v", msg)
+	}
+	a := msg.Attachments[0]
+	if a.Color != slackDanger || a.Title != "router (10.0.0.1) down" || slackValue(a, "State") != "up -> down" || slackValue(a, "Last seen") == "" {
+		t.Errorf("down attachment %+v", a)
+	}
+
+	up := testEvent(stateUp)
+	up.Transitions[0].DownSince = up.Transitions[0].UpSince.Add(-90 * time.Second)
+	a = slackNotify(t, up).Attachments[0]
+	if a.Color != slackGood || !strings.HasPrefix(slackValue(a, "Down for"), "1m30s (") {
+		t.Errorf("up attachment %+v", a)
+	}
+}
+
+func TestSlackNotices(t *testing.T) {
+	//路径MTU变化不是上线
+	a := slackNotify(t, testPMTUEvent()).Attachments[0]
+	if a.Color != slackWarning || a.Title != "router (10.0.0.1) path MTU 1400" ||
+		slackValue(a, "State") != "" || slackValue(a, "Path MTU") != "1400 (expected 1500)" {
+		t.Errorf("pmtu attachment %+v", a)
+	}
+
+	ev := testEvent(stateDown)
+	ev.Transitions[0].Kind = noticeTrace
+	ev.Transitions[0].Trace = &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}}}
+	a = slackNotify(t, ev).Attachments[0]
+	if a.Color != slackDanger || !strings.Contains(a.Text, "1 10.0.0.254 1ms") {
+		t.Errorf("trace attachment %+v", a)
+	}
+
+	g := &Transition{Kind: noticeGroup, Key: "a1", Name: "site1", Area: "a1", Group: "site1", From: groupUp, To: groupPartial,
+		Health: &groupHealth{Up: 3, Down: 1, Total: 4}}
+	a = slackNotify(t, &Event{Transitions: []*Transition{g}}).Attachments[0]
+	if a.Color != slackWarning || a.Title != "site1 partial" || slackValue(a, "Hosts") != "up 3, down 1, total 4" {
+		t.Errorf("group attachment %+v", a)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//短信的默认最大长度(字符)
+const defaultSMSLength = 70
+
+//短信模板的参数
+type smsData struct {
+	Phone   string
+	Message string
+}
+
+//短信模板函数: json输出JSON字符串，urlquery为内置函数
+var smsFuncs = template.FuncMap{
+	"json": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+}
+
+//检查短信模板
+func (c *NotifierConfig) checkSMS() error {
+	for name, s := range map[string]string{"url": c.URL, "body": c.Body} {
+		if _, err := template.New(name).Funcs(smsFuncs).Parse(s); err != nil {
+			return fmt.Errorf("notifier %s: %s template %s", c.Name, name, err)
+		}
+	}
+	for k, v := range c.Headers {
+		if _, err := template.New(k).Funcs(smsFuncs).Parse(v); err != nil {
+			return fmt.Errorf("notifier %s: header %s template %s", c.Name, k, err)
+		}
+	}
+	//截断时需要保留"..."
+	if c.MaxLength < 0 || (c.MaxLength > 0 && c.MaxLength < 4) {
+		return fmt.Errorf("notifier %s: max_length must be at least 4", c.Name)
+	}
+	switch c.Method {
+	case "", "GET", "POST", "PUT":
+	default:
+		return fmt.Errorf("notifier %s: method %q", c.Name, c.Method)
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("notifier %s: url %q", c.Name, c.URL)
+	}
+	return nil
+}
+
+func execTemplate(s string, data *smsData) (string, error) {
+	t, err := template.New("sms").Funcs(smsFuncs).Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+//短信网关: 模板生成请求，每个号码发送一次
+type smsNotifier struct {
+	c      *NotifierConfig
+	groups map[string]*Group
+}
+
+//区域的接收号码: 组的phones中可以是号码或者联系人名称，没有时使用渠道的phones
+func (n *smsNotifier) phones(area string) []string {
+	list := n.c.Phones
+	if g, ok := n.groups[area]; ok && len(g.Phones) > 0 {
+		list = g.Phones
+	}
+	var phones []string
+	for _, p := range list {
+		if phone, ok := n.c.Contacts[p]; ok {
+			p = phone
+		}
+		if !contains(phones, p) {
+			phones = append(phones, p)
+		}
+	}
+	return phones
+}
+
+//短信内容: 一台主机时包括地址和时间，多台时合并为摘要，超过长度时截断
+func (ev *Event) smsText(max int) string {
+	var text string
+	if len(ev.Transitions) == 1 {
+		text = ev.Transitions[0].smsText()
+	} else {
+		var down, up, pmtu, trace []string
+		for _, v := range ev.Transitions {
+			switch {
+			case v.Kind == noticePMTU:
+				pmtu = append(pmtu, v.Name)
+			case v.Kind == noticeTrace:
+				trace = append(trace, v.Name)
+			case v.up():
+				up = append(up, v.Name)
+			default:
+				down = append(down, v.Name)
+			}
+		}
+		var parts []string
+		if len(down) > 0 {
+			parts = append(parts, fmt.Sprintf("%d 台离线: %s", len(down), strings.Join(down, ", ")))
+		}
+		if len(up) > 0 {
+			parts = append(parts, fmt.Sprintf("%d 台上线: %s", len(up), strings.Join(up, ", ")))
+		}
+		if len(pmtu) > 0 {
+			parts = append(parts, fmt.Sprintf("%d 台路径MTU变化: %s", len(pmtu), strings.Join(pmtu, ", ")))
+		}
+		if len(trace) > 0 {
+			parts = append(parts, fmt.Sprintf("%d 台路由跟踪: %s", len(trace), strings.Join(trace, ", ")))
+		}
+		text = ev.AreaName + " " + strings.Join(parts, "; ")
+	}
+	if ev.Severity != "" {
+		text = "[" + strings.ToUpper(ev.Severity) + "] " + text
+	}
+
+	if r := []rune(text); len(r) > max {
+		if max > 3 {
+			text = string(r[:max-3]) + "..."
+		} else {
+			text = string(r[:max])
+		}
+	}
+	return text
+}
+
+//一台主机或者一个组的短信内容
+func (v *Transition) smsText() string {
+	var format = "01-02 15:04"
+	switch v.Kind {
+	case noticePMTU:
+		if v.PMTU < v.PMTUExpect {
+			return fmt.Sprintf("%s %s(%s) 路径MTU过低 %d (期望 %d)", v.Group, v.Name, v.Address, v.PMTU, v.PMTUExpect)
+		}
+		return fmt.Sprintf("%s %s(%s) 路径MTU恢复 %d", v.Group, v.Name, v.Address, v.PMTU)
+	case noticeGroup:
+		return fmt.Sprintf("%s 区域状态 %s (之前 %s)，在线 %d/%d", v.Name, v.To, v.From, v.Health.Up, v.Health.Total)
+	case noticeTrace:
+		text := fmt.Sprintf("%s %s(%s) 离线 路由跟踪", v.Group, v.Name, v.Address)
+		if v.Trace != nil {
+			//最后一个有回复的跳
+			for i := len(v.Trace.Hops) - 1; i >= 0; i-- {
+				if hop := v.Trace.Hops[i]; hop.Addr != "" {
+					return text + fmt.Sprintf(" 第 %d 跳 %s", hop.TTL, hop.Addr)
+				}
+			}
+		}
+		return text + " 没有回复"
+	}
+	if v.up() {
+		text := fmt.Sprintf("%s %s(%s) 上线 %s", v.Group, v.Name, v.Address, v.Last.Format(format))
+		if !v.DownSince.IsZero() && v.UpSince.After(v.DownSince) {
+			text += fmt.Sprintf("，离线 %s", v.UpSince.Sub(v.DownSince).Truncate(time.Minute))
+		}
+		return text
+	}
+	state := v.To
+	if state == stateDown {
+		state = "离线"
+	}
+	return fmt.Sprintf("%s %s(%s) %s 最近在线 %s", v.Group, v.Name, v.Address, state, v.Last.Format(format))
+}
+
+func (n *smsNotifier) Notify(ev *Event) error {
+	phones := n.phones(ev.Area)
+	if len(phones) == 0 {
+		return fmt.Errorf("no phones for %s", ev.Area)
+	}
+	max := n.c.MaxLength
+	if max <= 0 {
+		max = defaultSMSLength
+	}
+	method := n.c.Method
+	if method == "" {
+		method = "POST"
+	}
+	text := ev.smsText(max)
+
+	var errs []string
+	for _, phone := range phones {
+		data := &smsData{Phone: phone, Message: text}
+		if err := n.send(method, data); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", phone, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (n *smsNotifier) send(method string, data *smsData) error {
+	u, err := execTemplate(n.c.URL, data)
+	if err != nil {
+		return err
+	}
+	body, err := execTemplate(n.c.Body, data)
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string)
+	for k, v := range n.c.Headers {
+		if headers[k], err = execTemplate(v, data); err != nil {
+			return err
+		}
+	}
+	_, err = sendHTTP(n.c, method, u, headers, []byte(body))
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSMSText(t *testing.T) {
+	up := testEvent(stateUp)
+	down := testEvent(stateDown)
+	down.Severity = "critical"
+	trace := testEvent(stateDown)
+	trace.Transitions[0].Kind = noticeTrace
+	trace.Transitions[0].Trace = &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254"}, {TTL: 2}}}
+	g := &Transition{Kind: noticeGroup, Name: "site1", From: groupUp, To: groupDown, Health: &groupHealth{Up: 1, Total: 4}}
+	multi := testEvent(stateDown)
+	multi.Transitions = append(multi.Transitions, testEvent(stateUp).Transitions[0], testPMTUEvent().Transitions[0])
+	for _, tc := range []struct {
+		ev   *Event
+		want string
+	}{
+		{up, "site1 router(10.0.0.1) 上线 "},
+		{down, "[CRITICAL] site1 router(10.0.0.1) 离线 最近在线 "},
+		{testPMTUEvent(), "site1 router(10.0.0.1) 路径MTU过低 1400 (期望 1500)"},
+		{trace, "site1 router(10.0.0.1) 离线 路由跟踪 第 1 跳 10.0.0.254"},
+		{&Event{Transitions: []*Transition{g}}, "site1 区域状态 down (之前 up)，在线 1/4"},
+		{multi, "site1 1 台离线: router; 1 台上线: router; 1 台路径MTU变化: router"},
+	} {
+		if text := tc.ev.smsText(200); !strings.HasPrefix(text, tc.want) {
+			t.Errorf("smsText = %q, want %q", text, tc.want)
+		}
+	}
+}
+
+func TestSMSTextTruncate(t *testing.T) {
+	ev := testEvent(stateDown)
+	if text := ev.smsText(10); len([]rune(text)) != 10 || !strings.HasSuffix(text, "...") {
+		t.Errorf("smsText(10) = %q", text)
+	}
+	//长度小于"..."时不能panic
+	for _, max := range []int{1, 2, 3} {
+		if text := ev.smsText(max); len([]rune(text)) != max {
+			t.Errorf("smsText(%d) = %q", max, text)
+		}
+	}
+}
+
+func TestCheckSMS(t *testing.T) {
+	for _, tc := range []struct {
+		c  NotifierConfig
+		ok bool
+	}{
+		{NotifierConfig{URL: "https://sms.example.com/send?to={{.Phone}}"}, true},
+		{NotifierConfig{URL: "https://sms.example.com", MaxLength: 4}, true},
+		{NotifierConfig{URL: "https://sms.example.com", MaxLength: 3}, false},
+		{NotifierConfig{URL: "https://sms.example.com", MaxLength: -1}, false},
+		{NotifierConfig{URL: "https://sms.example.com", Body: "{{.Phone"}, false},
+		{NotifierConfig{URL: "https://sms.example.com", Method: "DELETE"}, false},
+		{NotifierConfig{URL: "sms.example.com"}, false},
+	} {
+		tc.c.Name = "sms"
+		if err := tc.c.checkSMS(); (err == nil) != tc.ok {
+			t.Errorf("%+v: err = %v", tc.c, err)
+		}
+	}
+}
+
+func TestSMSNotify(t *testing.T) {
+	s := newTestServer(t, http.StatusOK, "")
+	c := &NotifierConfig{
+		Name:     "sms",
+		URL:      s.URL + "/send?to={{.Phone}}",
+		Headers:  map[string]string{"X-Phone": "{{.Phone}}"},
+		Body:     `{"text": {{json .Message}}}`,
+		Phones:   []string{"13800000000"},
+		Contacts: map[string]string{"ops": "13900000000"},
+	}
+	n := &smsNotifier{c, map[string]*Group{"a1": {Phones: []string{"ops", "13700000000", "13900000000"}}}}
+	if err := n.Notify(testEvent(stateDown)); err != nil {
+		t.Fatal(err)
+	}
+	//联系人名称转换为号码并去重
+	if bodies := s.all(); len(bodies) != 2 || !strings.HasPrefix(string(bodies[0]), `{"text": "site1 router(10.0.0.1) 离线`) {
+		t.Errorf("bodies %q", bodies)
+	}
+	if req, _ := s.last(); req.Method != "POST" || req.URL.Query().Get("to") != "13700000000" || req.Header.Get("X-Phone") != "13700000000" {
+		t.Errorf("request %s %s %v", req.Method, req.URL, req.Header)
+	}
+	//没有组的号码时使用渠道的号码
+	if phones := n.phones("a2"); len(phones) != 1 || phones[0] != "13800000000" {
+		t.Errorf("phones = %v", phones)
+	}
+	//短信模板中可能包括密钥
+	if hc := c.hideSecret(); hc.Body != "******" || hc.URL != s.URL+"/******?******" {
+		t.Errorf("hideSecret = %+v", hc)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//默认的OID: NET-SNMP-MIB::netSnmpPlaypen.1，见etc/GOPING-MIB.txt
+const defaultEnterpriseOID = "1.3.6.1.4.1.8072.9999.9999.1"
+
+//标准OID
+const (
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+//SNMP trap配置
+type SNMPConfig struct {
+	//接收trap的地址，默认端口162
+	Receivers []string `json:"receivers"`
+	//版本: 2c或者3
+	Version   string `json:"version"`
+	Community string `json:"community,omitempty"`
+	//v3: 用户、认证(MD5或SHA)和加密(DES或AES)
+	User         string `json:"user,omitempty"`
+	AuthProtocol string `json:"auth_protocol,omitempty"`
+	AuthPassword string `json:"auth_password,omitempty"`
+	PrivProtocol string `json:"priv_protocol,omitempty"`
+	PrivPassword string `json:"priv_password,omitempty"`
+	//v3: 本机的engine ID(十六进制)，为空时根据主机名生成
+	EngineID string `json:"engine_id,omitempty"`
+	//MIB的OID，默认1.3.6.1.4.1.8072.9999.9999.1
+	EnterpriseOID string `json:"enterprise_oid,omitempty"`
+}
+
+//检查SNMP配置
+func (c *SNMPConfig) check() error {
+	if len(c.Receivers) == 0 {
+		return errors.New("snmp receivers is empty")
+	}
+	for _, r := range c.Receivers {
+		if _, _, err := net.SplitHostPort(receiverAddr(r)); err != nil {
+			return fmt.Errorf("snmp receiver %q: %s", r, err)
+		}
+	}
+	if _, err := berOID(c.enterprise()); err != nil {
+		return err
+	}
+	switch c.Version {
+	case "2c":
+		if c.Community == "" {
+			return errors.New("snmp community is empty")
+		}
+	case "3":
+		if c.User == "" {
+			return errors.New("snmp user is empty")
+		}
+		if _, err := c.engineID(); err != nil {
+			return err
+		}
+		switch c.AuthProtocol {
+		case "":
+			if c.PrivProtocol != "" {
+				return errors.New("snmp priv_protocol requires auth_protocol")
+			}
+		case "MD5", "SHA":
+			if len(c.AuthPassword) < 8 {
+				return errors.New("snmp auth_password must be at least 8 characters")
+			}
+		default:
+			return fmt.Errorf("snmp auth_protocol %q", c.AuthProtocol)
+		}
+		switch c.PrivProtocol {
+		case "":
+		case "DES", "AES":
+			if len(c.PrivPassword) < 8 {
+				return errors.New("snmp priv_password must be at least 8 characters")
+			}
+		default:
+			return fmt.Errorf("snmp priv_protocol %q", c.PrivProtocol)
+		}
+	default:
+		return fmt.Errorf("snmp version %q, want 2c or 3", c.Version)
+	}
+	return nil
+}
+
+//返回隐藏密码的配置
+func (c *SNMPConfig) hideSecret() *SNMPConfig {
+	hc := *c
+	for _, s := range []*string{&hc.Community, &hc.AuthPassword, &hc.PrivPassword} {
+		if *s != "" {
+			*s = "******"
+		}
+	}
+	return &hc
+}
+
+func (c *SNMPConfig) enterprise() string {
+	if c.EnterpriseOID != "" {
+		return strings.TrimPrefix(c.EnterpriseOID, ".")
+	}
+	return defaultEnterpriseOID
+}
+
+//engine ID: 配置的十六进制，或者net-snmp企业号格式加上"goping-主机名"
+func (c *SNMPConfig) engineID() ([]byte, error) {
+	if c.EngineID != "" {
+		id, err := hex.DecodeString(strings.TrimPrefix(c.EngineID, "0x"))
+		if err != nil || len(id) < 5 || len(id) > 32 {
+			return nil, fmt.Errorf("snmp engine_id %q", c.EngineID)
+		}
+		return id, nil
+	}
+	host, _ := os.Hostname()
+	text := "goping-" + host
+	if len(text) > 27 {
+		text = text[:27]
+	}
+	return append([]byte{0x80, 0x00, 0x1f, 0x88, 0x04}, text...), nil
+}
+
+func receiverAddr(s string) string {
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return net.JoinHostPort(strings.Trim(s, "[]"), "162")
+	}
+	return s
+}
+
+//BER编码
+func berTLV(tag byte, content []byte) []byte {
+	n := len(content)
+	var b []byte
+	switch {
+	case n < 0x80:
+		b = []byte{tag, byte(n)}
+	case n <= 0xff:
+		b = []byte{tag, 0x81, byte(n)}
+	default:
+		b = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	}
+	return append(b, content...)
+}
+
+func berSeq(items ...[]byte) []byte {
+	return berTLV(0x30, bytes.Join(items, nil))
+}
+
+func berInt(v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berTLV(0x02, b)
+}
+
+func berString(s []byte) []byte {
+	return berTLV(0x04, s)
+}
+
+//TimeTicks，单位为百分之一秒
+func berTimeTicks(v uint32) []byte {
+	b := make([]byte, 5)
+	binary.BigEndian.PutUint32(b[1:], v)
+	for len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0 {
+		b = b[1:]
+	}
+	return berTLV(0x43, b)
+}
+
+func berOID(s string) ([]byte, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("oid %q", s)
+	}
+	var ids []uint32
+	for _, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("oid %q", s)
+		}
+		ids = append(ids, uint32(n))
+	}
+	if ids[0] > 2 || ids[1] >= 40 {
+		return nil, fmt.Errorf("oid %q", s)
+	}
+	ids = append([]uint32{ids[0]*40 + ids[1]}, ids[2:]...)
+	var b []byte
+	for _, id := range ids {
+		var enc []byte
+		for {
+			enc = append([]byte{byte(id & 0x7f)}, enc...)
+			if id >>= 7; id == 0 {
+				break
+			}
+		}
+		for i := 0; i < len(enc)-1; i++ {
+			enc[i] |= 0x80
+		}
+		b = append(b, enc...)
+	}
+	return berTLV(0x06, b), nil
+}
+
+func mustOID(s string) []byte {
+	b, err := berOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+//SNMP trap通知: 每台主机的状态变化发送一个hostDown或者hostUp
+type snmpNotifier struct {
+	c     *SNMPConfig
+	start time.Time
+	msgID int32
+	//v3: engine ID、启动次数和本地化的密钥
+	engineID []byte
+	boots    int64
+	authKey  []byte
+	privKey  []byte
+}
+
+//dir为保存snmpEngineBoots的目录
+func newSNMPNotifier(c *SNMPConfig, dir string) (*snmpNotifier, error) {
+	n := &snmpNotifier{c: c, start: time.Now()}
+	if c.Version != "3" {
+		return n, nil
+	}
+	id, err := c.engineID()
+	if err != nil {
+		return nil, err
+	}
+	n.engineID = id
+	//接收端拒绝boots和time小于已记录值的消息，重启后time从0开始，所以boots必须增加
+	n.boots, err = nextEngineBoots(filepath.Join(dir, hex.EncodeToString(id)+".boots"))
+	if err != nil {
+		return nil, err
+	}
+	if c.AuthProtocol != "" {
+		n.authKey = localizeKey(n.hash(), c.AuthPassword, id)
+	}
+	if c.PrivProtocol != "" {
+		n.privKey = localizeKey(n.hash(), c.PrivPassword, id)
+	}
+	return n, nil
+}
+
+//读取保存的snmpEngineBoots，加1后写回(RFC 3414 2.2)；文件不存在时从1开始
+func nextEngineBoots(file string) (int64, error) {
+	var boots int64
+	bs, err := ioutil.ReadFile(file)
+	switch {
+	case err == nil:
+		boots, err = strconv.ParseInt(strings.TrimSpace(string(bs)), 10, 64)
+		if err != nil || boots < 0 {
+			return 0, fmt.Errorf("snmp engine boots %s: invalid content %q", file, bs)
+		}
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+	//达到最大值后保持不变，需要更换engine ID或者密钥
+	if boots < 2147483647 {
+		boots++
+	}
+	Mkdir(filepath.Dir(file))
+	if err := ioutil.WriteFile(file, []byte(strconv.FormatInt(boots, 10)+"\n"), 0644); err != nil {
+		return 0, err
+	}
+	return boots, nil
+}
+
+//trap的变量: GOPING-MIB中的gopingObjects；trap为hostDown(1)、hostUp(2)、
+//pathMTULow(3)、pathMTURecovered(4)和groupStateChange(5)
+func (n *snmpNotifier) varbinds(t *Transition) []byte {
+	base := n.c.enterprise()
+	var trap string
+	switch {
+	case t.Kind == noticePMTU && t.firing():
+		trap = base + ".0.3"
+	case t.Kind == noticePMTU:
+		trap = base + ".0.4"
+	case t.Kind == noticeGroup:
+		trap = base + ".0.5"
+	case t.firing():
+		trap = base + ".0.1"
+	default:
+		trap = base + ".0.2"
+	}
+	var since string
+	if !t.DownSince.IsZero() {
+		since = t.DownSince.Format(time.RFC3339)
+	}
+	uptime := uint32(time.Since(n.start) / (10 * time.Millisecond))
+	vbs := [][]byte{
+		berSeq(mustOID(oidSysUpTime), berTimeTicks(uptime)),
+		berSeq(mustOID(oidSnmpTrapOID), mustOID(trap)),
+	}
+	for i, v := range []string{t.Area, t.Group, t.Name, t.Address, t.RTT, t.To, t.From, since} {
+		oid := fmt.Sprintf("%s.1.%d.0", base, i+1)
+		vbs = append(vbs, berSeq(mustOID(oid), berString([]byte(v))))
+	}
+	var ints []int
+	switch t.Kind {
+	case noticePMTU:
+		ints = []int{9, t.PMTU, 10, t.PMTUExpect}
+	case noticeGroup:
+		ints = []int{11, t.Health.Down, 12, t.Health.Total}
+	}
+	for i := 0; i < len(ints); i += 2 {
+		oid := fmt.Sprintf("%s.1.%d.0", base, ints[i])
+		vbs = append(vbs, berSeq(mustOID(oid), berInt(int64(ints[i+1]))))
+	}
+	return berSeq(vbs...)
+}
+
+//SNMPv2-Trap-PDU
+func (n *snmpNotifier) pdu(t *Transition) []byte {
+	id := atomic.AddInt32(&n.msgID, 1)
+	return berTLV(0xa7, bytes.Join([][]byte{berInt(int64(id)), berInt(0), berInt(0), n.varbinds(t)}, nil))
+}
+
+func (n *snmpNotifier) Notify(ev *Event) error {
+	var errs []string
+	for _, t := range ev.Transitions {
+		//离线提醒不重复发送；路由跟踪结果没有对应的trap
+		if !t.incident() || t.Kind == noticeTrace {
+			continue
+		}
+		var msg []byte
+		var err error
+		if n.c.Version == "3" {
+			msg, err = n.v3Message(n.pdu(t))
+		} else {
+			msg = berSeq(berInt(1), berString([]byte(n.c.Community)), n.pdu(t))
+		}
+		if err != nil {
+			return err
+		}
+		for _, r := range n.c.Receivers {
+			if err := sendUDP(receiverAddr(r), msg); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", r, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func sendUDP(addr string, msg []byte) error {
+	conn, err := net.DialTimeout("udp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg)
+	return err
+}
+
+//认证使用的hash
+func (n *snmpNotifier) hash() func() hash.Hash {
+	if n.c.AuthProtocol == "SHA" {
+		return sha1.New
+	}
+	return md5.New
+}
+
+//RFC 3414 A.2: 密码转换为本地化的密钥
+func localizeKey(h func() hash.Hash, password string, engineID []byte) []byte {
+	d := h()
+	buf := make([]byte, 1<<20)
+	for i := range buf {
+		buf[i] = password[i%len(password)]
+	}
+	d.Write(buf)
+	ku := d.Sum(nil)
+	d = h()
+	d.Write(ku)
+	d.Write(engineID)
+	d.Write(ku)
+	return d.Sum(nil)
+}
+
+//SNMPv3消息: USM认证和加密
+func (n *snmpNotifier) v3Message(pdu []byte) ([]byte, error) {
+	engineID := n.engineID
+	boots, engineTime := n.boots, int64(time.Since(n.start)/time.Second)
+	scoped := berSeq(berString(engineID), berString(nil), pdu)
+
+	var flags byte
+	var authParams, privParams []byte
+	if n.c.AuthProtocol != "" {
+		flags |= 0x01
+		authParams = make([]byte, 12)
+	}
+	data := scoped
+	if n.c.PrivProtocol != "" {
+		flags |= 0x02
+		key := n.privKey
+		salt := make([]byte, 8)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		var encrypted []byte
+		if n.c.PrivProtocol == "AES" {
+			iv := make([]byte, 16)
+			binary.BigEndian.PutUint32(iv, uint32(boots))
+			binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+			copy(iv[8:], salt)
+			block, err := aes.NewCipher(key[:16])
+			if err != nil {
+				return nil, err
+			}
+			encrypted = make([]byte, len(scoped))
+			cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scoped)
+			privParams = salt
+		} else {
+			//DES: salt为engineBoots和随机数
+			binary.BigEndian.PutUint32(salt, uint32(boots))
+			iv := make([]byte, 8)
+			for i := range iv {
+				iv[i] = key[8+i] ^ salt[i]
+			}
+			block, err := des.NewCipher(key[:8])
+			if err != nil {
+				return nil, err
+			}
+			padded := append([]byte{}, scoped...)
+			for len(padded)%8 != 0 {
+				padded = append(padded, 0)
+			}
+			encrypted = make([]byte, len(padded))
+			cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+			privParams = salt
+		}
+		data = berString(encrypted)
+	}
+
+	secParams := berSeq(
+		berString(engineID),
+		berInt(boots),
+		berInt(engineTime),
+		berString([]byte(n.c.User)),
+		berString(authParams),
+		berString(privParams),
+	)
+	id := atomic.AddInt32(&n.msgID, 1)
+	header := berSeq(berInt(int64(id)), berInt(65507), berString([]byte{flags}), berInt(3))
+	msg := berSeq(berInt(3), header, berString(secParams), data)
+
+	if n.c.AuthProtocol != "" {
+		//认证参数先置零，计算整个消息的HMAC后取前12字节
+		mac := hmac.New(n.hash(), n.authKey)
+		mac.Write(msg)
+		placeholder := berString(authParams)
+		i := bytes.Index(msg, placeholder)
+		if i < 0 {
+			return nil, errors.New("snmp auth parameters not found")
+		}
+		copy(msg[i+2:], mac.Sum(nil)[:12])
+	}
+	return msg, nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//解析一个BER TLV，返回标签、内容和剩余部分
+func berDecode(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, fmt.Errorf("short tlv % x", b)
+	}
+	tag, n, i := b[0], int(b[1]), 2
+	switch b[1] {
+	case 0x81:
+		n, i = int(b[2]), 3
+	case 0x82:
+		n, i = int(b[2])<<8|int(b[3]), 4
+	}
+	if len(b) < i+n {
+		return 0, nil, nil, fmt.Errorf("tlv length %d > %d", n, len(b)-i)
+	}
+	return tag, b[i : i+n], b[i+n:], nil
+}
+
+//解析SEQUENCE中的全部项
+func berItems(t *testing.T, b []byte) [][]byte {
+	var items [][]byte
+	for len(b) > 0 {
+		_, _, rest, err := berDecode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, b[:len(b)-len(rest)])
+		b = rest
+	}
+	return items
+}
+
+func berContent(t *testing.T, b []byte, tag byte) []byte {
+	got, content, _, err := berDecode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != tag {
+		t.Fatalf("tag %#x, want %#x: % x", got, tag, b)
+	}
+	return content
+}
+
+func TestBerInt(t *testing.T) {
+	for _, tc := range []struct {
+		v    int64
+		want string
+	}{
+		{0, "020100"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{256, "02020100"},
+		{65507, "020300ffe3"},
+		{-1, "0201ff"},
+		{-129, "0202ff7f"},
+	} {
+		if got := hex.EncodeToString(berInt(tc.v)); got != tc.want {
+			t.Errorf("berInt(%d) = %s, want %s", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestBerTimeTicks(t *testing.T) {
+	for _, tc := range []struct {
+		v    uint32
+		want string
+	}{
+		{0, "430100"},
+		{128, "43020080"},
+		{0xffffffff, "430500ffffffff"},
+	} {
+		if got := hex.EncodeToString(berTimeTicks(tc.v)); got != tc.want {
+			t.Errorf("berTimeTicks(%d) = %s, want %s", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestBerLength(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{127, "047f"},
+		{200, "0481c8"},
+		{300, "0482012c"},
+	} {
+		b := berString(make([]byte, tc.n))
+		if got := hex.EncodeToString(b[:len(b)-tc.n]); got != tc.want {
+			t.Errorf("length %d header %s, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestBerOID(t *testing.T) {
+	for _, tc := range []struct {
+		oid  string
+		want string
+	}{
+		{oidSysUpTime, "06082b06010201010300"},
+		{"1.3.6.1.4.1.8072", "06072b06010401bf08"},
+		{"2.39.268435455", "060577ffffff7f"},
+	} {
+		b, err := berOID(tc.oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(b); got != tc.want {
+			t.Errorf("berOID(%s) = %s, want %s", tc.oid, got, tc.want)
+		}
+	}
+	for _, s := range []string{"1", "3.1", "1.40", "1.3.a", "1.3.4294967296"} {
+		if _, err := berOID(s); err == nil {
+			t.Errorf("berOID(%q): want error", s)
+		}
+	}
+}
+
+//RFC 3414 A.3的密钥本地化示例
+func TestLocalizeKey(t *testing.T) {
+	id, _ := hex.DecodeString("000000000000000000000002")
+	if got := hex.EncodeToString(localizeKey(md5.New, "maplesyrup", id)); got != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Errorf("md5 key = %s", got)
+	}
+	if got := hex.EncodeToString(localizeKey(sha1.New, "maplesyrup", id)); got != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("sha key = %s", got)
+	}
+}
+
+func TestSNMPConfigCheck(t *testing.T) {
+	for _, tc := range []struct {
+		c  SNMPConfig
+		ok bool
+	}{
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "2c", Community: "public"}, true},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "2c"}, false},
+		{SNMPConfig{Version: "2c", Community: "public"}, false},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "1", Community: "public"}, false},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "3", User: "u", AuthProtocol: "SHA", AuthPassword: "12345678", PrivProtocol: "AES", PrivPassword: "12345678"}, true},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "3", User: "u", AuthProtocol: "SHA", AuthPassword: "short"}, false},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "3", User: "u", PrivProtocol: "AES", PrivPassword: "12345678"}, false},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "3", User: "u", EngineID: "0102"}, false},
+		{SNMPConfig{Receivers: []string{"10.0.0.5"}, Version: "2c", Community: "public", EnterpriseOID: "1.x"}, false},
+	} {
+		if err := tc.c.check(); (err == nil) != tc.ok {
+			t.Errorf("%+v: err = %v", tc.c, err)
+		}
+	}
+}
+
+func TestEngineBoots(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snmp", "x.boots")
+	for i := int64(1); i <= 3; i++ {
+		boots, err := nextEngineBoots(file)
+		if err != nil || boots != i {
+			t.Fatalf("boots = %d, %v, want %d", boots, err, i)
+		}
+	}
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nextEngineBoots(file); err == nil {
+		t.Error("want error for invalid content")
+	}
+}
+
+//接收一个UDP报文
+func recvTrap(t *testing.T, n *snmpNotifier, ev *Event) []byte {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	n.c.Receivers = []string{conn.LocalAddr().String()}
+	if err := n.Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 65535)
+	k, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:k]
+}
+
+//检查trap PDU: 变量为sysUpTime、snmpTrapOID和8个gopingObjects，返回全部变量
+func checkTrapPDU(t *testing.T, pdu []byte, trap string) [][]byte {
+	items := berItems(t, berContent(t, pdu, 0xa7))
+	if len(items) != 4 {
+		t.Fatalf("pdu has %d items, want 4", len(items))
+	}
+	vbs := berItems(t, berContent(t, items[3], 0x30))
+	if len(vbs) < 10 {
+		t.Fatalf("%d varbinds, want at least 10", len(vbs))
+	}
+	vb := berItems(t, berContent(t, vbs[1], 0x30))
+	if !bytes.Equal(vb[0], mustOID(oidSnmpTrapOID)) || !bytes.Equal(vb[1], mustOID(trap)) {
+		t.Errorf("snmpTrapOID varbind % x, want %s", vbs[1], trap)
+	}
+	vb = berItems(t, berContent(t, vbs[4], 0x30))
+	if !bytes.Equal(vb[0], mustOID(defaultEnterpriseOID+".1.3.0")) || string(berContent(t, vb[1], 0x04)) != "router" {
+		t.Errorf("name varbind % x", vbs[4])
+	}
+	return vbs
+}
+
+func TestSNMPv2c(t *testing.T) {
+	n, err := newSNMPNotifier(&SNMPConfig{Version: "2c", Community: "public"}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		state string
+		trap  string
+	}{
+		{stateDown, defaultEnterpriseOID + ".0.1"},
+		{stateUp, defaultEnterpriseOID + ".0.2"},
+	} {
+		items := berItems(t, berContent(t, recvTrap(t, n, testEvent(tc.state)), 0x30))
+		if len(items) != 3 || !bytes.Equal(items[0], berInt(1)) || string(berContent(t, items[1], 0x04)) != "public" {
+			t.Fatalf("message items % x", items)
+		}
+		if vbs := checkTrapPDU(t, items[2], tc.trap); len(vbs) != 10 {
+			t.Errorf("%d varbinds, want 10", len(vbs))
+		}
+	}
+}
+
+func TestSNMPNotices(t *testing.T) {
+	n, err := newSNMPNotifier(&SNMPConfig{Version: "2c", Community: "public"}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := func(ev *Event) []byte {
+		items := berItems(t, berContent(t, recvTrap(t, n, ev), 0x30))
+		return items[2]
+	}
+	//路径MTU变化不是hostUp
+	for _, tc := range []struct {
+		pmtu int
+		trap string
+	}{
+		{1400, defaultEnterpriseOID + ".0.3"},
+		{1500, defaultEnterpriseOID + ".0.4"},
+	} {
+		ev := testPMTUEvent()
+		ev.Transitions[0].PMTU = tc.pmtu
+		vbs := checkTrapPDU(t, pdu(ev), tc.trap)
+		if len(vbs) != 12 {
+			t.Fatalf("%d varbinds, want 12", len(vbs))
+		}
+		vb := berItems(t, berContent(t, vbs[10], 0x30))
+		if !bytes.Equal(vb[0], mustOID(defaultEnterpriseOID+".1.9.0")) || !bytes.Equal(vb[1], berInt(int64(tc.pmtu))) {
+			t.Errorf("pmtu varbind % x", vbs[10])
+		}
+		vb = berItems(t, berContent(t, vbs[11], 0x30))
+		if !bytes.Equal(vb[0], mustOID(defaultEnterpriseOID+".1.10.0")) || !bytes.Equal(vb[1], berInt(1500)) {
+			t.Errorf("pmtu expect varbind % x", vbs[11])
+		}
+	}
+
+	g := &Transition{Kind: noticeGroup, Key: "a1", Name: "router", Area: "a1", Group: "site1", From: groupUp, To: groupPartial,
+		Health: &groupHealth{Down: 1, Total: 4}}
+	vbs := checkTrapPDU(t, pdu(&Event{Transitions: []*Transition{g}}), defaultEnterpriseOID+".0.5")
+	if len(vbs) != 12 {
+		t.Fatalf("%d varbinds, want 12", len(vbs))
+	}
+	vb := berItems(t, berContent(t, vbs[11], 0x30))
+	if !bytes.Equal(vb[0], mustOID(defaultEnterpriseOID+".1.12.0")) || !bytes.Equal(vb[1], berInt(4)) {
+		t.Errorf("total varbind % x", vbs[11])
+	}
+
+	//离线提醒和路由跟踪结果不发送trap
+	remind := testEvent(stateDown)
+	remind.Transitions[0].From, remind.Transitions[0].Remind = stateDown, 1
+	trace := testEvent(stateDown)
+	trace.Transitions[0].Kind = noticeTrace
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	n.c.Receivers = []string{conn.LocalAddr().String()}
+	for _, ev := range []*Event{remind, trace} {
+		if err := n.Notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if k, _, err := conn.ReadFrom(make([]byte, 65535)); err == nil {
+		t.Errorf("unexpected trap of %d bytes", k)
+	}
+}
+
+func TestSNMPv3(t *testing.T) {
+	dir := t.TempDir()
+	c := &SNMPConfig{Version: "3", User: "goping", AuthProtocol: "SHA", AuthPassword: "authpass1",
+		PrivProtocol: "AES", PrivPassword: "privpass1", EngineID: "80001f8804676f70696e67"}
+	//模拟重启: 第二次创建时boots为2
+	newSNMPNotifier(c, dir)
+	n, err := newSNMPNotifier(c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := recvTrap(t, n, testEvent(stateDown))
+
+	items := berItems(t, berContent(t, msg, 0x30))
+	if len(items) != 4 || !bytes.Equal(items[0], berInt(3)) {
+		t.Fatalf("message items % x", items)
+	}
+	header := berItems(t, berContent(t, items[1], 0x30))
+	if flags := berContent(t, header[2], 0x04); !bytes.Equal(flags, []byte{0x03}) {
+		t.Errorf("flags % x, want 03", flags)
+	}
+	sec := berItems(t, berContent(t, berContent(t, items[2], 0x04), 0x30))
+	if len(sec) != 6 {
+		t.Fatalf("security parameters % x", sec)
+	}
+	engineID := berContent(t, sec[0], 0x04)
+	if hex.EncodeToString(engineID) != "80001f8804676f70696e67" {
+		t.Errorf("engine id % x", engineID)
+	}
+	//重启后boots增加
+	if !bytes.Equal(sec[1], berInt(2)) {
+		t.Errorf("boots % x, want 2", sec[1])
+	}
+	if string(berContent(t, sec[3], 0x04)) != "goping" {
+		t.Errorf("user % x", sec[3])
+	}
+
+	//认证: 参数置零后计算HMAC-SHA-96
+	authParams := berContent(t, sec[4], 0x04)
+	if len(authParams) != 12 {
+		t.Fatalf("auth parameters % x", authParams)
+	}
+	zeroed := append([]byte{}, msg...)
+	i := bytes.Index(zeroed, authParams)
+	copy(zeroed[i:], make([]byte, 12))
+	key := localizeKey(sha1.New, "authpass1", engineID)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(zeroed)
+	if !hmac.Equal(mac.Sum(nil)[:12], authParams) {
+		t.Error("auth parameters do not match HMAC")
+	}
+
+	//加密: AES-CFB，IV为boots、time和salt
+	salt := berContent(t, sec[5], 0x04)
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, 2)
+	var engineTime int64
+	for _, b := range berContent(t, sec[2], 0x02) {
+		engineTime = engineTime<<8 | int64(b)
+	}
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	block, err := aes.NewCipher(localizeKey(sha1.New, "privpass1", engineID)[:16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := berContent(t, items[3], 0x04)
+	scoped := make([]byte, len(encrypted))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(scoped, encrypted)
+	parts := berItems(t, berContent(t, scoped, 0x30))
+	if len(parts) != 3 || !bytes.Equal(berContent(t, parts[0], 0x04), engineID) {
+		t.Fatalf("scoped pdu % x", scoped)
+	}
+	checkTrapPDU(t, parts[2], defaultEnterpriseOID+".0.1")
+}
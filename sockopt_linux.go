@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+//设置发送报文的TTL(IPv6为hop limit)和DSCP
+func setProbeSockopt(conn *net.IPConn, v4 bool, ttl, dscp int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if v4 {
+			if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl); serr != nil {
+				return
+			}
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+		} else {
+			if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl); serr != nil {
+				return
+			}
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+//设置Don't Fragment: 忽略内核缓存的路径MTU，超过出接口MTU时发送返回EMSGSIZE
+func setDontFragment(conn *net.IPConn, v4 bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if v4 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+//将socket绑定到网络接口: 报文只从此接口发送
+func bindToDevice(conn *net.IPConn, ifname string) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+//非linux系统不支持设置TTL和DSCP，只能使用默认值
+func setProbeSockopt(conn *net.IPConn, v4 bool, ttl, dscp int) error {
+	if ttl != defaultTTL || dscp != 0 {
+		return errors.New("ttl and dscp are only supported on linux")
+	}
+	return nil
+}
+
+func setDontFragment(conn *net.IPConn, v4 bool) error {
+	return errors.New("don't fragment is only supported on linux")
+}
+
+//非linux系统只绑定接口地址
+func bindToDevice(conn *net.IPConn, ifname string) error {
+	return nil
+}
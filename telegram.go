@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTelegramAPI = "https://api.telegram.org"
+	//Telegram消息的最大长度
+	telegramMaxLen = 4000
+)
+
+//Telegram Bot API: 按区域选择chat_ids中的会话，没有时使用chat_id
+type telegramNotifier struct {
+	c *NotifierConfig
+}
+
+//通知内容转换为Telegram HTML: 与SendMail的内容相同
+func (ev *Event) telegramHTML() string {
+	var format = "2006-01-02 15:04:05"
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n\n", html.EscapeString(ev.Subject))
+	for _, o := range ev.Outages {
+		fmt.Fprintf(&b, "<b>站点故障: %s %d/%d 台离线 (%d%%)</b>\n",
+			html.EscapeString(o.Name), len(o.Down), o.Total, len(o.Down)*100/o.Total)
+		for _, v := range o.Down {
+			fmt.Fprintf(&b, "%s %s 最近在线: %s\n",
+				html.EscapeString(v.Name), html.EscapeString(v.Address), v.Last.Format(format))
+		}
+		b.WriteString("\n")
+	}
+	for i, v := range ev.rest() {
+		name, addr := html.EscapeString(v.Name), html.EscapeString(v.Address)
+		switch v.Kind {
+		case noticePMTU:
+			status := "路径MTU恢复"
+			if v.PMTU < v.PMTUExpect {
+				status = "路径MTU过低"
+			}
+			fmt.Fprintf(&b, "%d、%s：%s <b>%s</b>\n路径MTU: %d (期望 %d)\n", i+1, name, addr, status, v.PMTU, v.PMTUExpect)
+		case noticeGroup:
+			gh := v.Health
+			fmt.Fprintf(&b, "%s：<b>%s</b> (之前: %s)\n在线 %d，离线 %d，总数 %d\n时间: %s\n",
+				name, v.To, v.From, gh.Up, gh.Down, gh.Total, v.Last.Format(format))
+		case noticeTrace:
+			fmt.Fprintf(&b, "%d、%s：%s <b>%s</b>\n最近在线: %s\n", i+1, name, addr, v.To, v.Last.Format(format))
+			if v.Trace != nil {
+				fmt.Fprintf(&b, "路由跟踪:\n<pre>%s</pre>\n", html.EscapeString(v.Trace.String()))
+			}
+		default:
+			if v.up() {
+				fmt.Fprintf(&b, "%d、%s：%s <b>上线</b>\n恢复时间: %s\n", i+1, name, addr, v.Last.Format(format))
+				if !v.DownSince.IsZero() && v.UpSince.After(v.DownSince) {
+					fmt.Fprintf(&b, "离线 %s (%s 至 %s)\n", v.UpSince.Sub(v.DownSince).Truncate(time.Second),
+						v.DownSince.Format(format), v.UpSince.Format(format))
+				}
+			} else {
+				fmt.Fprintf(&b, "%d、%s：%s <b>%s</b>\n最近在线: %s\n", i+1, name, addr, v.To, v.Last.Format(format))
+				if v.Remind > 0 {
+					fmt.Fprintf(&b, "第 %d 次提醒，已离线 %s\n", v.Remind, time.Since(v.AlertAt).Truncate(time.Minute))
+				}
+				if len(v.Unreachable) > 0 {
+					fmt.Fprintf(&b, "下游不可达 %d 台: %s\n", len(v.Unreachable), html.EscapeString(strings.Join(v.Unreachable, ", ")))
+				}
+			}
+			if v.PMTUExpect > 0 && v.PMTU > 0 {
+				fmt.Fprintf(&b, "路径MTU: %d (期望 %d)\n", v.PMTU, v.PMTUExpect)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (n *telegramNotifier) Notify(ev *Event) error {
+	chat := n.c.ChatID
+	if id, ok := n.c.ChatIDs[ev.Area]; ok {
+		chat = id
+	}
+	if chat == "" {
+		return fmt.Errorf("no chat id for %s", ev.Area)
+	}
+	base := n.c.URL
+	if base == "" {
+		base = defaultTelegramAPI
+	}
+	msg := map[string]interface{}{
+		"chat_id":                  chat,
+		"text":                     truncate(ev.telegramHTML(), telegramMaxLen),
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}
+	b, err := postJSON(n.c, strings.TrimRight(base, "/")+"/bot"+n.c.Token+"/sendMessage", n.c.Headers, msg)
+	if err != nil {
+		//不在日志中记录token
+		return fmt.Errorf("%s", strings.Replace(err.Error(), n.c.Token, "******", -1))
+	}
+	var r struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram: %s", r.Description)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTelegramChat(t *testing.T) {
+	for _, tc := range []struct {
+		chatID  string
+		chatIDs map[string]string
+		want    string
+	}{
+		{"-100", nil, "-100"},
+		{"-100", map[string]string{"a1": "-200"}, "-200"},
+		{"-100", map[string]string{"a2": "-200"}, "-100"},
+	} {
+		s := newTestServer(t, 200, `{"ok":true}`)
+		c := &NotifierConfig{Name: "tg", URL: s.URL + "/", Token: "123:abc", ChatID: tc.chatID, ChatIDs: tc.chatIDs}
+		if err := (&telegramNotifier{c}).Notify(testEvent(stateDown)); err != nil {
+			t.Fatal(err)
+		}
+		req, body := s.last()
+		if req.URL.Path != "/bot123:abc/sendMessage" {
+			t.Errorf("path = %q", req.URL.Path)
+		}
+		var msg struct {
+			ChatID    string `json:"chat_id"`
+			Text      string `json:"text"`
+			ParseMode string `json:"parse_mode"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ChatID != tc.want {
+			t.Errorf("chat_id = %q, want %q", msg.ChatID, tc.want)
+		}
+		if msg.ParseMode != "HTML" || !strings.Contains(msg.Text, "router：10.0.0.1 <b>down</b>") {
+			t.Errorf("body = %s", body)
+		}
+	}
+}
+
+func TestTelegramNoChat(t *testing.T) {
+	c := &NotifierConfig{Name: "tg", URL: "http://127.0.0.1:1", Token: "x", ChatIDs: map[string]string{"a2": "-200"}}
+	if err := (&telegramNotifier{c}).Notify(testEvent(stateDown)); err == nil {
+		t.Error("want error without chat id")
+	}
+}
+
+func TestTelegramError(t *testing.T) {
+	s := newTestServer(t, 200, `{"ok":false,"description":"Bad Request: chat not found"}`)
+	c := &NotifierConfig{Name: "tg", URL: s.URL, Token: "123:abc", ChatID: "-100"}
+	err := (&telegramNotifier{c}).Notify(testEvent(stateDown))
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("err = %v", err)
+	}
+
+	//错误信息中不包括token
+	s = newTestServer(t, 401, `{"ok":false,"description":"Unauthorized"}`)
+	c.URL = s.URL
+	err = (&telegramNotifier{c}).Notify(testEvent(stateDown))
+	if err == nil || strings.Contains(err.Error(), "123:abc") {
+		t.Errorf("err = %v, want token hidden", err)
+	}
+}
+
+func TestTelegramHTMLEscape(t *testing.T) {
+	ev := testEvent(stateUp)
+	ev.Subject = "<a1> & site1"
+	ev.Transitions[0].Name = "r<1>"
+	text := ev.telegramHTML()
+	for _, s := range []string{"<b>&lt;a1&gt; &amp; site1</b>", "r&lt;1&gt;：10.0.0.1 <b>上线</b>", "离线 1m0s"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("text %q does not contain %q", text, s)
+		}
+	}
+}
+
+func TestTelegramNotices(t *testing.T) {
+	text := testPMTUEvent().telegramHTML()
+	if !strings.Contains(text, "router：10.0.0.1 <b>路径MTU过低</b>\n路径MTU: 1400 (期望 1500)") || strings.Contains(text, "上线") {
+		t.Errorf("pmtu: %q", text)
+	}
+
+	ev := testEvent(stateDown)
+	ev.Transitions[0].Kind = noticeTrace
+	ev.Transitions[0].Trace = &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}}, Err: "<timeout>"}
+	if text = ev.telegramHTML(); !strings.Contains(text, "<pre>1 10.0.0.254 1ms\n&lt;timeout&gt;\n</pre>") {
+		t.Errorf("trace: %q", text)
+	}
+
+	g := &Transition{Kind: noticeGroup, Key: "a1", Name: "site1", Area: "a1", Group: "site1", From: groupPartial, To: groupUp,
+		Health: &groupHealth{Up: 4, Total: 4}}
+	ev = &Event{Subject: "[a1] site1 - 区域状态变化通知: up", Transitions: []*Transition{g}}
+	if text = ev.telegramHTML(); !strings.Contains(text, "site1：<b>up</b> (之前: partial)\n在线 4，离线 0，总数 4") {
+		t.Errorf("group: %q", text)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+//路径跟踪的并发数: 与离线时的路由跟踪分开限制
+const pathConcurrency = 2
+
+//主机状态
+const (
+	stateUp   = "up"
+	stateDown = "down"
+	//上级设备离线导致不可达
+	stateUnreachable = "unreachable"
+)
+
+//主机在全部分组中的唯一名称: area/name
+func hostKey(area, name string) string {
+	return area + "/" + name
+}
+
+//上级设备的唯一名称: parent可以是同组主机名称，或者area/name
+func parentKey(area, parent string) string {
+	if strings.Contains(parent, "/") {
+		return parent
+	}
+	return hostKey(area, parent)
+}
+
+//检查主机依赖: 上级设备必须存在，并且不能有循环
+func checkParents(groups map[string]*Group) error {
+	parents := make(map[string]string)
+	for area, g := range groups {
+		for _, h := range g.Hosts {
+			parents[hostKey(area, h.Name)] = ""
+		}
+	}
+	for area, g := range groups {
+		for _, h := range g.Hosts {
+			if h.Parent == "" {
+				continue
+			}
+			key, pkey := hostKey(area, h.Name), parentKey(area, h.Parent)
+			if _, ok := parents[pkey]; !ok {
+				return fmt.Errorf("host %s: parent %s not found", key, h.Parent)
+			}
+			parents[key] = pkey
+		}
+	}
+
+	//沿上级设备查找，经过的主机数超过总数则存在循环
+	for key := range parents {
+		path := []string{key}
+		for p := parents[key]; p != ""; p = parents[p] {
+			path = append(path, p)
+			if p == key || len(path) > len(parents) {
+				return fmt.Errorf("host dependency cycle: %s", strings.Join(path, " -> "))
+			}
+		}
+	}
+	return nil
+}
+
+//根据配置设置主机的上级和下级设备
+func linkParents(hosts []*Host, parents map[string]string) {
+	keys := make(map[string]*Host)
+	for _, h := range hosts {
+		keys[hostKey(h.AreaID, h.Name)] = h
+	}
+	for key, pkey := range parents {
+		h, p := keys[key], keys[pkey]
+		if h == nil || p == nil {
+			continue
+		}
+		h.parent = p
+		p.children = append(p.children, h)
+	}
+}
+
+//返回离线或者正在失败的上级设备
+func (h *Host) failedParent() *Host {
+	for p := h.parent; p != nil; p = p.parent {
+		if !p.Stat || p.Times > 0 {
+			return p
+		}
+	}
+	return nil
+}
+
+//返回因为本设备离线而不可达的下级设备
+func (h *Host) unreachable() []*Host {
+	var hs []*Host
+	for _, c := range h.children {
+		if c.State == stateUnreachable {
+			hs = append(hs, c)
+			hs = append(hs, c.unreachable()...)
+		}
+	}
+	return hs
+}
+
+//路径跟踪结果: 地址解析失败时trace为nil
+type pathResult struct {
+	host  *Host
+	trace *traceroute
+}
+
+//拓扑图中的节点: 路径中的一跳或者监控主机
+type topoNode struct {
+	Addr string `json:"address"`
+	//对应的监控主机: area/name
+	Host string `json:"host,omitempty"`
+	//经过此节点的监控主机数量
+	Behind int `json:"behind"`
+}
+
+type topoEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+//建议的上级设备
+type topoSuggestion struct {
+	Host    string `json:"host"`
+	Addr    string `json:"address"`
+	Current string `json:"current,omitempty"`
+	//建议的上级设备: area/name，路径中没有监控主机时为空
+	Parent string `json:"parent,omitempty"`
+	//上级设备或者多个主机共用的上游地址
+	Via string `json:"via"`
+}
+
+//根据路径跟踪推断的拓扑
+type topology struct {
+	Nodes       []*topoNode       `json:"nodes"`
+	Edges       []*topoEdge       `json:"edges"`
+	Suggestions []*topoSuggestion `json:"suggestions"`
+}
+
+//定期跟踪到全部在线主机的路径，结果发送到m.path；上一轮还没有完成时跳过
+func (m *monitor) tracePaths() {
+	if m.pathPending > 0 {
+		m.logger.Printf("[WARN] topology: skip path tracing, %d hosts pending\n", m.pathPending)
+		return
+	}
+	for _, pr := range m.probes {
+		for raddr, host := range pr.hosts {
+			if !host.Stat {
+				continue
+			}
+			m.pathPending++
+			go func(opt probeOption, raddr string, host *Host) {
+				ip, err := net.ResolveIPAddr("ip", raddr)
+				if err != nil {
+					m.path <- &pathResult{host, nil}
+					return
+				}
+				m.pathSem <- true
+				t := traceRoute(opt, ip)
+				<-m.pathSem
+				m.path <- &pathResult{host, t}
+			}(pr.opt, raddr, host)
+		}
+	}
+}
+
+//保存路径跟踪结果
+func (m *monitor) updatePath(r *pathResult) {
+	m.pathPending--
+	if r.trace == nil {
+		return
+	}
+	m.mu.Lock()
+	r.host.path = r.trace
+	m.mu.Unlock()
+}
+
+//节点名称: 不同命名空间的相同地址是不同的节点
+func nodeKey(netns, addr string) string {
+	if netns == "" {
+		return addr
+	}
+	return netns + "|" + addr
+}
+
+//从主机路径中找出共用的上游节点，并推断上级设备
+func (m *monitor) topology() *topology {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	//地址对应的监控主机
+	hosts := make(map[string]*Host)
+	for _, pr := range m.probes {
+		for raddr, h := range pr.hosts {
+			hosts[nodeKey(pr.opt.Netns, raddr)] = h
+		}
+	}
+
+	nodes := make(map[string]*topoNode)
+	edges := make(map[topoEdge]bool)
+	//每个主机的路径节点，不包括主机本身
+	paths := make(map[*Host][]string)
+	for _, pr := range m.probes {
+		for raddr, h := range pr.hosts {
+			if h.path == nil || !h.path.Reached {
+				continue
+			}
+			var prev string
+			var path []string
+			for _, hop := range h.path.Hops {
+				if hop.Addr == "" {
+					prev = ""
+					continue
+				}
+				key := nodeKey(pr.opt.Netns, hop.Addr)
+				n, ok := nodes[key]
+				if !ok {
+					n = &topoNode{Addr: hop.Addr}
+					if hh, ok := hosts[key]; ok {
+						n.Host = hostKey(hh.AreaID, hh.Name)
+					}
+					nodes[key] = n
+				}
+				if hop.Addr != raddr {
+					n.Behind++
+					path = append(path, key)
+				}
+				if prev != "" && prev != key {
+					edges[topoEdge{nodes[prev].Addr, hop.Addr}] = true
+				}
+				prev = key
+			}
+			paths[h] = path
+		}
+	}
+
+	t := &topology{
+		Nodes:       make([]*topoNode, 0),
+		Edges:       make([]*topoEdge, 0),
+		Suggestions: make([]*topoSuggestion, 0),
+	}
+	for _, n := range nodes {
+		t.Nodes = append(t.Nodes, n)
+	}
+	for e := range edges {
+		e := e
+		t.Edges = append(t.Edges, &e)
+	}
+	for h, path := range paths {
+		var s *topoSuggestion
+		//从最近的一跳开始查找监控主机，没有时使用多个主机共用的最近一跳
+		for i := len(path) - 1; i >= 0; i-- {
+			n := nodes[path[i]]
+			if n.Host != "" {
+				s = &topoSuggestion{Parent: n.Host, Via: n.Addr}
+				break
+			}
+			if s == nil && n.Behind > 1 {
+				s = &topoSuggestion{Via: n.Addr}
+			}
+		}
+		if s == nil || (s.Parent != "" && s.Parent == h.Parent) {
+			continue
+		}
+		s.Host, s.Addr, s.Current = hostKey(h.AreaID, h.Name), h.Addr, h.Parent
+		t.Suggestions = append(t.Suggestions, s)
+	}
+	return t
+}
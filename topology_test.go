@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParentKey(t *testing.T) {
+	if got := parentKey("a1", "core"); got != "a1/core" {
+		t.Errorf("parentKey = %q", got)
+	}
+	if got := parentKey("a1", "a2/core"); got != "a2/core" {
+		t.Errorf("parentKey = %q", got)
+	}
+}
+
+func TestCheckParents(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		groups map[string]*Group
+		err    string
+	}{
+		{"ok", map[string]*Group{
+			"a1": {Hosts: []*jsonhost{{Name: "core"}, {Name: "sw1", Parent: "core"}, {Name: "ap1", Parent: "sw1"}}},
+			"a2": {Hosts: []*jsonhost{{Name: "sw2", Parent: "a1/core"}}},
+		}, ""},
+		{"missing", map[string]*Group{
+			"a1": {Hosts: []*jsonhost{{Name: "sw1", Parent: "core"}}},
+		}, "parent core not found"},
+		{"other area", map[string]*Group{
+			"a1": {Hosts: []*jsonhost{{Name: "core"}}},
+			"a2": {Hosts: []*jsonhost{{Name: "sw2", Parent: "core"}}},
+		}, "parent core not found"},
+		{"self", map[string]*Group{
+			"a1": {Hosts: []*jsonhost{{Name: "sw1", Parent: "sw1"}}},
+		}, "cycle"},
+		{"cycle", map[string]*Group{
+			"a1": {Hosts: []*jsonhost{{Name: "sw1", Parent: "a2/sw2"}, {Name: "ap1", Parent: "sw1"}}},
+			"a2": {Hosts: []*jsonhost{{Name: "sw2", Parent: "a1/sw1"}}},
+		}, "cycle"},
+	} {
+		err := checkParents(tc.groups)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	core := &Host{Name: "core", AreaID: "a1"}
+	sw := &Host{Name: "sw1", AreaID: "a1", State: stateUnreachable, Stat: true}
+	ap := &Host{Name: "ap1", AreaID: "a1", State: stateUnreachable, Stat: true}
+	other := &Host{Name: "sw2", AreaID: "a1", State: stateUp, Stat: true}
+	linkParents([]*Host{core, sw, ap, other}, map[string]string{
+		"a1/sw1": "a1/core",
+		"a1/ap1": "a1/sw1",
+		"a1/sw2": "a1/core",
+		"a1/x":   "a1/core",
+	})
+	if sw.parent != core || ap.parent != sw || len(core.children) != 2 {
+		t.Fatalf("links: sw1 parent %v, ap1 parent %v, core children %d", sw.parent, ap.parent, len(core.children))
+	}
+	if got := ap.failedParent(); got != core {
+		t.Errorf("failedParent = %v, want core", got)
+	}
+	if got := core.unreachable(); len(got) != 2 || got[0] != sw || got[1] != ap {
+		t.Errorf("unreachable = %v, want sw1, ap1", got)
+	}
+	core.Stat = true
+	if got := ap.failedParent(); got != nil {
+		t.Errorf("failedParent = %v, want nil", got)
+	}
+}
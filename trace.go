@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	icmpv4TimeExceeded = 11
+	icmpv4Unreachable  = 3
+	icmpv6TimeExceeded = 3
+	icmpv6Unreachable  = 1
+
+	//路由跟踪最大跳数、等待时间和并发数
+	traceMaxHops     = 30
+	traceTimeout     = 3 * time.Second
+	traceConcurrency = 8
+)
+
+//路由跟踪的一跳: 没有回复时Addr为空
+type traceHop struct {
+	TTL  int    `json:"ttl"`
+	Addr string `json:"address,omitempty"`
+	RTT  string `json:"rtt,omitempty"`
+}
+
+//一次路由跟踪的结果
+type traceroute struct {
+	Time    time.Time  `json:"time"`
+	Reached bool       `json:"reached"`
+	Hops    []traceHop `json:"hops"`
+	Err     string     `json:"error,omitempty"`
+}
+
+//路由跟踪结果
+type traceResult struct {
+	host  *Host
+	trace *traceroute
+}
+
+func (t *traceroute) String() string {
+	var s string
+	for _, hop := range t.Hops {
+		if hop.Addr == "" {
+			s += fmt.Sprintf("%d *\n", hop.TTL)
+		} else {
+			s += fmt.Sprintf("%d %s %s\n", hop.TTL, hop.Addr, hop.RTT)
+		}
+	}
+	if t.Err != "" {
+		s += t.Err + "\n"
+	}
+	return s
+}
+
+//ICMP路由跟踪: 同时发送TTL为1到traceMaxHops的Echo，序号等于TTL，
+//在traceTimeout内收集超时和回复报文
+func traceRoute(opt probeOption, ip *net.IPAddr) *traceroute {
+	t := &traceroute{Time: time.Now()}
+	p := NewPinger(opt)
+	v4 := isIPv4(ip.IP)
+	c, err := p.listen(v4)
+	if err != nil {
+		t.Err = err.Error()
+		return t
+	}
+	defer c.Close()
+
+	sent := make(map[int]time.Time)
+	for ttl := 1; ttl <= traceMaxHops; ttl++ {
+		if err := setProbeSockopt(c, v4, ttl, p.DSCP); err != nil {
+			t.Err = err.Error()
+			return t
+		}
+		p.seq = ttl
+		sent[ttl] = time.Now()
+		if _, err := c.WriteTo(p.echo(ip.IP), ip); err != nil {
+			t.Err = err.Error()
+			return t
+		}
+	}
+
+	hops := make(map[int]traceHop)
+	//到达目标或者不可达的最小TTL
+	last := traceMaxHops
+	b := make([]byte, 65536)
+	c.SetReadDeadline(time.Now().Add(traceTimeout))
+	for {
+		n, ra, err := c.ReadFrom(b)
+		if err != nil {
+			break
+		}
+		ttl, kind, ok := p.parseTrace(b[:n], v4)
+		if !ok || ttl < 1 || ttl > traceMaxHops {
+			continue
+		}
+		if _, ok := hops[ttl]; ok {
+			continue
+		}
+		hop := traceHop{
+			TTL:  ttl,
+			Addr: ra.(*net.IPAddr).String(),
+			RTT:  time.Since(sent[ttl]).String(),
+		}
+		switch kind {
+		case traceReply:
+			if !t.Reached || ttl < last {
+				last = ttl
+			}
+			t.Reached = true
+		case traceUnreachable:
+			hop.Addr += " !"
+			if !t.Reached && ttl < last {
+				last = ttl
+			}
+		}
+		hops[ttl] = hop
+		if (t.Reached || kind == traceUnreachable) && complete(hops, last) {
+			break
+		}
+	}
+
+	for ttl := 1; ttl <= last; ttl++ {
+		hop, ok := hops[ttl]
+		if !ok {
+			hop = traceHop{TTL: ttl}
+		}
+		t.Hops = append(t.Hops, hop)
+	}
+	//目标不可达时去掉末尾没有回复的跳
+	if !t.Reached {
+		for len(t.Hops) > 0 && t.Hops[len(t.Hops)-1].Addr == "" {
+			t.Hops = t.Hops[:len(t.Hops)-1]
+		}
+	}
+	return t
+}
+
+//1到last跳都已经收到回复
+func complete(hops map[int]traceHop, last int) bool {
+	for ttl := 1; ttl <= last; ttl++ {
+		if _, ok := hops[ttl]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+//路由跟踪收到的ICMP报文类型
+const (
+	traceExceeded = iota
+	traceReply
+	traceUnreachable
+)
+
+//解析路由跟踪收到的ICMP报文，返回原始报文的TTL(序号)和报文类型
+func (p *Pinger) parseTrace(b []byte, v4 bool) (int, int, bool) {
+	if len(b) < icmpHeaderLen {
+		return 0, 0, false
+	}
+	switch {
+	case b[0] == icmpv4EchoReply || b[0] == icmpv6EchoReply:
+		if int(binary.BigEndian.Uint16(b[4:])) != p.id {
+			return 0, 0, false
+		}
+		return int(binary.BigEndian.Uint16(b[6:])), traceReply, true
+	case v4 && (b[0] == icmpv4TimeExceeded || b[0] == icmpv4Unreachable),
+		!v4 && (b[0] == icmpv6TimeExceeded || b[0] == icmpv6Unreachable):
+		//ICMP差错报文中包含原始IP头部和ICMP头部
+		orig := b[icmpHeaderLen:]
+		hlen := 40
+		if v4 {
+			if len(orig) < 20 {
+				return 0, 0, false
+			}
+			hlen = int(orig[0]&0x0f) * 4
+		}
+		if len(orig) < hlen+icmpHeaderLen {
+			return 0, 0, false
+		}
+		echo := orig[hlen:]
+		if int(binary.BigEndian.Uint16(echo[4:])) != p.id {
+			return 0, 0, false
+		}
+		kind := traceExceeded
+		if (v4 && b[0] == icmpv4Unreachable) || (!v4 && b[0] == icmpv6Unreachable) {
+			kind = traceUnreachable
+		}
+		return int(binary.BigEndian.Uint16(echo[6:])), kind, true
+	}
+	return 0, 0, false
+}
+
+//主机离线时进行路由跟踪，结果发送到m.trace；notice为true时离线通知已经发送，
+//跟踪完成后发送跟踪结果。上一次跟踪还没有完成时不重复跟踪
+func (m *monitor) startTrace(opt probeOption, raddr string, host *Host, notice bool) {
+	if n, ok := m.tracing[host]; ok {
+		m.tracing[host] = n || notice
+		return
+	}
+	m.tracing[host] = notice
+	go func() {
+		ip, err := net.ResolveIPAddr("ip", raddr)
+		if err != nil {
+			m.trace <- &traceResult{host, &traceroute{Time: time.Now(), Err: err.Error()}}
+			return
+		}
+		m.traceSem <- true
+		t := traceRoute(opt, ip)
+		<-m.traceSem
+		m.trace <- &traceResult{host, t}
+	}()
+}
+
+//保存路由跟踪结果: 离线通知已经发送，主机仍然离线时单独发送跟踪结果
+func (m *monitor) traced(r *traceResult) {
+	notice := m.tracing[r.host]
+	delete(m.tracing, r.host)
+	r.host.Trace = r.trace
+	m.debug("[DEBUG] area: %s, %s traceroute:\n%s", r.host.Area, r.host.Name, r.trace)
+	if !notice {
+		return
+	}
+	if r.host.Stat {
+		m.debug("[DEBUG] %s recovered during traceroute\n", r.host.Name)
+		return
+	}
+	m.sendNotice(r.host, noticeTrace)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+//构造ICMP差错报文: 包含原始IP头部和Echo头部
+func traceError(typ byte, ipHeader int, echo []byte) []byte {
+	b := make([]byte, icmpHeaderLen+ipHeader)
+	b[0] = typ
+	if ipHeader == 20 {
+		b[icmpHeaderLen] = 0x45
+	}
+	return append(b, echo...)
+}
+
+func TestParseTrace(t *testing.T) {
+	p := NewPinger(probeOption{})
+	p.seq = 5
+	echo4 := p.echo(net.ParseIP("10.0.0.1"))
+	echo6 := p.echo(net.ParseIP("2001:db8::1"))
+
+	reply := append([]byte{}, echo4...)
+	reply[0] = icmpv4EchoReply
+	other := append([]byte{}, echo4...)
+	binary.BigEndian.PutUint16(other[4:], uint16(p.id+1))
+
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		v4   bool
+		ttl  int
+		kind int
+		ok   bool
+	}{
+		{"reply", reply, true, 5, traceReply, true},
+		{"v4 exceeded", traceError(icmpv4TimeExceeded, 20, echo4), true, 5, traceExceeded, true},
+		{"v4 unreachable", traceError(icmpv4Unreachable, 20, echo4), true, 5, traceUnreachable, true},
+		{"v6 exceeded", traceError(icmpv6TimeExceeded, 40, echo6), false, 5, traceExceeded, true},
+		{"v6 unreachable", traceError(icmpv6Unreachable, 40, echo6), false, 5, traceUnreachable, true},
+		//其他进程的报文
+		{"other id", traceError(icmpv4TimeExceeded, 20, other), true, 0, 0, false},
+		//IPv4的超时类型在IPv6中不是差错报文
+		{"v4 type on v6", traceError(icmpv4TimeExceeded, 40, echo6), false, 0, 0, false},
+		{"truncated", traceError(icmpv4TimeExceeded, 20, echo4)[:icmpHeaderLen+20+4], true, 0, 0, false},
+		{"short", []byte{icmpv4TimeExceeded, 0}, true, 0, 0, false},
+	} {
+		ttl, kind, ok := p.parseTrace(tc.b, tc.v4)
+		if ok != tc.ok || ttl != tc.ttl || kind != tc.kind {
+			t.Errorf("%s: got %d %d %v, want %d %d %v", tc.name, ttl, kind, ok, tc.ttl, tc.kind, tc.ok)
+		}
+	}
+}
+
+func TestTracerouteString(t *testing.T) {
+	tr := &traceroute{
+		Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}, {TTL: 2}},
+		Err:  "timeout",
+	}
+	if s := tr.String(); s != "1 10.0.0.254 1ms\n2 *\ntimeout\n" {
+		t.Errorf("String() = %q", s)
+	}
+	if !complete(map[int]traceHop{1: {}, 2: {}}, 2) || complete(map[int]traceHop{2: {}}, 2) {
+		t.Error("complete")
+	}
+	if strings.Contains((&traceroute{}).String(), "*") {
+		t.Error("empty trace")
+	}
+}
+
+func TestTraced(t *testing.T) {
+	down := &Host{Name: "r1", Addr: "10.0.0.1"}
+	up := &Host{Name: "r2", Addr: "10.0.0.2", Stat: true}
+	m := &monitor{
+		mail:    make(chan *Transition, 2),
+		cfg:     &Config{},
+		tracing: map[*Host]bool{down: true, up: true},
+	}
+	tr := &traceroute{Hops: []traceHop{{TTL: 1, Addr: "10.0.0.254", RTT: "1ms"}}}
+	m.traced(&traceResult{down, tr})
+	m.traced(&traceResult{up, tr})
+	close(m.mail)
+
+	var sent []*Transition
+	for t := range m.mail {
+		sent = append(sent, t)
+	}
+	//跟踪期间恢复的主机不再发送跟踪结果
+	if len(sent) != 1 || sent[0].Name != "r1" || sent[0].Kind != noticeTrace || sent[0].Trace != tr {
+		t.Fatalf("sent %v", sent)
+	}
+	if down.Trace != tr || up.Trace != tr || len(m.tracing) != 0 {
+		t.Errorf("host trace %v tracing %v", down.Trace, m.tracing)
+	}
+
+	ev := m.newEvent("", sent)
+	if body := mailBody(ev); !strings.Contains(ev.Subject, "路由跟踪结果通知") ||
+		!strings.Contains(body, "1 10.0.0.254 1ms") || strings.Contains(body, "上线") {
+		t.Errorf("subject %q body %q", ev.Subject, body)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	//通知渠道HTTP请求的默认超时
+	defaultNotifyTimeout = 10 * time.Second
+	//webhook签名的请求头: sha256=<hex>
+	signatureHeader = "X-Goping-Signature"
+)
+
+//通知渠道HTTP请求重试的间隔，第n次重试等待n倍
+var retryDelay = time.Second
+
+//检查渠道的地址
+func checkURL(name, s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("notifier %s: url %q", name, s)
+	}
+	return nil
+}
+
+//发送HTTP请求: 网络错误、429和5xx时重试，返回响应内容
+func sendHTTP(c *NotifierConfig, method, url string, headers map[string]string, body []byte) ([]byte, error) {
+	client := &http.Client{Timeout: c.timeout()}
+	var err error
+	for i := 0; i <= c.Retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * retryDelay)
+		}
+		var b []byte
+		var retry bool
+		b, retry, err = doHTTP(client, method, url, headers, body)
+		if err == nil || !retry {
+			return b, err
+		}
+	}
+	return nil, err
+}
+
+func doHTTP(client *http.Client, method, url string, headers map[string]string, body []byte) ([]byte, bool, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return b, retry, fmt.Errorf("%s %s: %s %s", method, url, resp.Status, bytes.TrimSpace(b))
+	}
+	return b, false, nil
+}
+
+//发送JSON，返回响应内容
+func postJSON(c *NotifierConfig, url string, headers map[string]string, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	h := map[string]string{"Content-Type": "application/json"}
+	for k, v := range headers {
+		h[k] = v
+	}
+	return sendHTTP(c, "POST", url, h, body)
+}
+
+//webhook通知: POST事件JSON，设置secret时签名
+type webhookNotifier struct {
+	c *NotifierConfig
+}
+
+func (n *webhookNotifier) Notify(ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"User-Agent":   "goping",
+	}
+	for k, v := range n.c.Headers {
+		headers[k] = v
+	}
+	if n.c.Secret != "" {
+		headers[signatureHeader] = "sha256=" + sign(n.c.Secret, body)
+	}
+	_, err = sendHTTP(n.c, "POST", n.c.URL, headers, body)
+	return err
+}
+
+//HMAC-SHA256签名，十六进制
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}